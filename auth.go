@@ -0,0 +1,193 @@
+package mgo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Credential holds details to authenticate with a MongoDB server.
+type Credential struct {
+	// Username and Password hold the basic details for authentication.
+	// Password is optional with some authentication mechanisms.
+	Username string
+	Password string
+
+	// Source is the database used to establish credentials and privileges
+	// with a MongoDB server. Defaults to the default database provided
+	// during dial, or "admin" if that was unset. The GSSAPI, MONGODB-X509
+	// and PLAIN mechanisms always default to "$external".
+	Source string
+
+	// Service defines the service name to use when authenticating with the GSSAPI
+	// mechanism. Defaults to "mongodb".
+	Service string
+
+	// ServiceHost defines which hostname to use when authenticating
+	// with the GSSAPI mechanism. If not specified, defaults to the MongoDB
+	// server's address.
+	ServiceHost string
+
+	// Mechanism defines the protocol for credential negotiation.
+	// Supported values are "SCRAM-SHA-1", "SCRAM-SHA-256", "MONGODB-CR",
+	// "MONGODB-X509" (or "X509"), "PLAIN" and "GSSAPI". If empty, the
+	// mechanism is negotiated with the server.
+	Mechanism string
+}
+
+// authMechanisms maps the accepted mechanism names to the driver ones.
+var authMechanisms = map[string]string{
+	"":              "",
+	"SCRAM-SHA-1":   "SCRAM-SHA-1",
+	"SCRAM-SHA-256": "SCRAM-SHA-256",
+	"MONGODB-CR":    "MONGODB-CR",
+	"MONGODB-X509":  "MONGODB-X509",
+	"X509":          "MONGODB-X509",
+	"PLAIN":         "PLAIN",
+	"GSSAPI":        "GSSAPI",
+}
+
+// auth converts the credential into its driver representation. The
+// database is the default database of the session, used to pick the
+// source when the credential does not define one.
+func (cred *Credential) auth(database string) (options.Credential, error) {
+	mechanism, ok := authMechanisms[strings.ToUpper(cred.Mechanism)]
+	if !ok {
+		return options.Credential{}, fmt.Errorf("unsupported authentication mechanism: %q", cred.Mechanism)
+	}
+	if cred.Username == "" && mechanism != "MONGODB-X509" {
+		return options.Credential{}, errors.New("authentication requires a username")
+	}
+	source := cred.Source
+	if source == "" {
+		source = defaultAuthSource(mechanism, database)
+	}
+	auth := options.Credential{
+		AuthMechanism: mechanism,
+		AuthSource:    source,
+		Username:      cred.Username,
+		Password:      cred.Password,
+		PasswordSet:   cred.Password != "",
+	}
+	if cred.Service != "" || cred.ServiceHost != "" {
+		if mechanism != "GSSAPI" {
+			return options.Credential{}, fmt.Errorf("service name and host are only supported by the GSSAPI mechanism, not %q", cred.Mechanism)
+		}
+		auth.AuthMechanismProperties = map[string]string{}
+		if cred.Service != "" {
+			auth.AuthMechanismProperties["SERVICE_NAME"] = cred.Service
+		}
+		if cred.ServiceHost != "" {
+			auth.AuthMechanismProperties["SERVICE_HOST"] = cred.ServiceHost
+		}
+	}
+	return auth, nil
+}
+
+// Login authenticates with MongoDB using the provided credential. The
+// authentication is valid for the whole session and is kept by sessions
+// obtained from it with Copy and Clone, but not with New.
+//
+// The driver authenticates connections with a single user, so logging in
+// switches the session to a connection pool dedicated to cred, shared by
+// every session logged in with the same credential, and limited by the
+// pool limit of the dialed one. Logging in again replaces the previous
+// credential.
+func (s *Session) Login(cred *Credential) error {
+	if cred == nil {
+		return errors.New("Session.Login: nil credential")
+	}
+	s.m.RLock()
+	if s.shared {
+		s.m.RUnlock()
+		return errors.New("Session.Login: sessions returned by WithContext cannot log in; use a copy instead")
+	}
+	login := *cred
+	if login.Source == "" {
+		login.Source = defaultAuthSource(login.Mechanism, s.database)
+	}
+	if s.cred != nil && *s.cred == login {
+		s.m.RUnlock()
+		return nil
+	}
+	current, ctx := s.mongoCluster(), contextOrBackground(s.ctx)
+	current.Acquire()
+	s.m.RUnlock()
+
+	// The login cluster is connected without holding the session lock, so
+	// that the session may be used meanwhile.
+	cluster, err := current.login(ctx, &login)
+	current.Release()
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cluster == nil {
+		cluster.Release()
+		return errors.New("Session.Login: session closed while logging in")
+	}
+	prev := s.cluster
+	s.cluster = cluster
+	s.cred = &login
+	s.restartCausal()
+	prev.Release()
+	return nil
+}
+
+// LogoutAll removes all established authentication credentials for the
+// session, reverting to the credentials used when dialing.
+func (s *Session) LogoutAll() {
+	s.m.Lock()
+	s.logout()
+	s.m.Unlock()
+}
+
+// logout switches back to the dialed cluster. The caller must hold the
+// session lock.
+func (s *Session) logout() {
+	if s.cred == nil {
+		return
+	}
+	root := s.mongoCluster().rootCluster()
+	root.Acquire()
+	prev := s.cluster
+	s.cluster = root
+	s.cred = nil
+	s.restartCausal()
+	prev.Release()
+}
+
+// defaultAuthSource returns the authentication database used with the
+// given mechanism when the credential does not define one.
+func defaultAuthSource(mechanism, database string) string {
+	switch authMechanisms[strings.ToUpper(mechanism)] {
+	case "MONGODB-X509", "PLAIN", "GSSAPI":
+		return "$external"
+	}
+	if database != "" {
+		return database
+	}
+	return "admin"
+}
+
+// Login authenticates with MongoDB using the provided credential.  The
+// authentication is valid for the whole session and will stay valid until
+// Logout is explicitly called for the same database, or the session is
+// closed. See Session.Login.
+func (d *Database) Login(user, pass string) error {
+	return d.session.Login(&Credential{Username: user, Password: pass, Source: d.database.Name()})
+}
+
+// Logout removes any established authentication credentials for the
+// database.
+func (d *Database) Logout() {
+	s := d.session
+	s.m.Lock()
+	if s.cred != nil && s.cred.Source == d.database.Name() {
+		s.logout()
+	}
+	s.m.Unlock()
+}
@@ -0,0 +1,88 @@
+package mgo
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func TestCredential_Auth(t *testing.T) {
+	Convey("credentials are mapped to driver credentials", t, func() {
+		auth, err := (&Credential{Username: "user", Password: "pass"}).auth("mydb")
+		So(err, ShouldBeNil)
+		So(auth, ShouldResemble, options.Credential{AuthSource: "mydb", Username: "user", Password: "pass", PasswordSet: true})
+
+		auth, err = (&Credential{Username: "user", Password: "pass", Mechanism: "scram-sha-256"}).auth("")
+		So(err, ShouldBeNil)
+		So(auth.AuthMechanism, ShouldEqual, "SCRAM-SHA-256")
+		So(auth.AuthSource, ShouldEqual, "admin")
+
+		auth, err = (&Credential{Mechanism: "X509"}).auth("mydb")
+		So(err, ShouldBeNil)
+		So(auth, ShouldResemble, options.Credential{AuthMechanism: "MONGODB-X509", AuthSource: "$external"})
+
+		auth, err = (&Credential{Username: "user", Password: "pass", Mechanism: "PLAIN"}).auth("mydb")
+		So(err, ShouldBeNil)
+		So(auth.AuthSource, ShouldEqual, "$external")
+
+		auth, err = (&Credential{Username: "user", Mechanism: "GSSAPI", Service: "mongo", ServiceHost: "db.example.com"}).auth("")
+		So(err, ShouldBeNil)
+		So(auth.AuthMechanismProperties, ShouldResemble, map[string]string{"SERVICE_NAME": "mongo", "SERVICE_HOST": "db.example.com"})
+
+		_, err = (&Credential{Username: "user", Mechanism: "SCRAM-SHA-1", Service: "mongo"}).auth("")
+		So(err, ShouldErrorMatche, "service name and host are only supported by the GSSAPI mechanism.*")
+		_, err = (&Credential{Username: "user", Mechanism: "NTLM"}).auth("")
+		So(err, ShouldErrorMatche, `unsupported authentication mechanism: "NTLM"`)
+		_, err = (&Credential{Password: "pass"}).auth("")
+		So(err, ShouldErrorMatche, "authentication requires a username")
+	})
+	Convey("sessions created from a driver client cannot log in", t, func() {
+		session := unconnectedSession("test")
+		err := session.Login(&Credential{Username: "user", Password: "pass"})
+		So(err, ShouldErrorMatche, "Session.Login: .*NewFromMongoDriver.*")
+	})
+	Convey("nil credentials are rejected", t, func() {
+		session := unconnectedSession("test")
+		var err error
+		So(func() { err = session.Login(nil) }, ShouldNotPanic)
+		So(err.Error(), ShouldEqual, "Session.Login: nil credential")
+	})
+	Convey("login clusters share the operation limiter of the dialed cluster", t, func() {
+		root := &cluster{}
+		login := &cluster{root: root}
+		So(login.opLimiter(), ShouldEqual, &root.limiter)
+		So(root.opLimiter(), ShouldEqual, &root.limiter)
+	})
+}
+
+func TestSession_Login(t *testing.T) {
+	AuthMongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo.Copy()
+		defer session.Close()
+		coll := session.DB("mydb").C("mycoll")
+		So(coll.Insert(M{"n": 1}), ShouldNotBeNil)
+
+		err := session.Login(&Credential{Username: "crawlab", Password: "wrong", Source: "admin"})
+		So(err, ShouldNotBeNil)
+
+		err = session.DB("admin").Login("crawlab", "crawlab_mgo")
+		So(err, ShouldBeNil)
+		So(session.DB("mydb").C("mycoll").Insert(M{"n": 1}), ShouldBeNil)
+
+		_, done, err := session.DB("mydb").begin(nil)
+		So(err, ShouldBeNil)
+		So(ctx.mongo.cluster.limiter.inUse, ShouldEqual, 1)
+		done()
+
+		scopy := session.Copy()
+		So(scopy.DB("mydb").C("mycoll").Insert(M{"n": 2}), ShouldBeNil)
+		fresh := scopy.New()
+		So(fresh.DB("mydb").C("mycoll").Insert(M{"n": 3}), ShouldNotBeNil)
+		fresh.Close()
+		scopy.Close()
+
+		session.LogoutAll()
+		So(session.DB("mydb").C("mycoll").Insert(M{"n": 4}), ShouldNotBeNil)
+		So(ctx.mongo.cluster.logins, ShouldBeEmpty)
+	})
+}
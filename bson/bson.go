@@ -98,3 +98,7 @@ func IsObjectIdHex(s string) bool {
 }
 
 type Raw = bson.RawValue
+
+// Timestamp represents a BSON timestamp, as used by the oplog and the
+// operation and cluster times of the server.
+type Timestamp = primitive.Timestamp
@@ -89,9 +89,17 @@ func (b *Bulk) Run(others ...*options.BulkWriteOptions) (br *BulkResult, bulkerr
 	for _, other := range others {
 		opts = options.MergeBulkWriteOptions(opts, other)
 	}
-	result, err := b.c.collection.BulkWrite(nil, b.models, opts)
+	ctx, done, err := b.c.begin(nil)
 	if err != nil {
-		var bulkError = &BulkError{ecases: []BulkErrorCase{}}
+		return nil, err
+	}
+	defer done()
+	result, err := b.c.collection.BulkWrite(ctx, b.models, opts)
+	if err == mongo.ErrUnacknowledgedWrite {
+		return &BulkResult{}, nil
+	}
+	if err != nil {
+		var bulkError = &BulkError{ecases: []BulkErrorCase{}, err: err}
 		switch newType := err.(type) {
 		case mongo.BulkWriteException:
 			for _, e := range newType.WriteErrors {
@@ -132,6 +140,9 @@ func (e *BulkError) Cases() []BulkErrorCase {
 }
 func (e *BulkError) Error() string {
 	if len(e.ecases) == 0 {
+		if e.err != nil {
+			return e.err.Error()
+		}
 		return "invalid BulkError instance: no errors"
 	}
 	if len(e.ecases) == 1 {
@@ -159,8 +170,15 @@ func (e *BulkError) Error() string {
 	return buf.String()
 }
 
+// Unwrap returns the driver error the bulk operation failed with, so the
+// labels of server errors may be inspected with errors.As.
+func (e *BulkError) Unwrap() error {
+	return e.err
+}
+
 // BulkError holds an error returned from running a Bulk operation.
 // Individual errors may be obtained and inspected via the Cases method.
 type BulkError struct {
 	ecases []BulkErrorCase
+	err    error
 }
@@ -0,0 +1,102 @@
+package mgo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetCausalConsistency sets whether the operations of the session run
+// within a causally consistent driver session. When enabled, the cluster
+// and operation times of every operation are tracked and sent along with
+// the following ones, so reads observe the preceding writes of the session
+// even when sent to secondaries by SetMode, as long as writes are
+// acknowledged. The guarantees hold across elections and network
+// partitions only with majority write and read concerns. See SetSafe.
+//
+// Sessions obtained with New, Copy and Clone run within their own causally
+// consistent driver session, starting from the cluster and operation times
+// the original session had. Driver sessions must not be used concurrently,
+// so a causally consistent session must not be used by several goroutines
+// at once either; copy it instead.
+//
+// Database and Collection values obtained from the session before the call
+// keep running within the driver session they were created with, which is
+// ended once causal consistency is disabled or the session is closed.
+// Sessions returned by WithContext share the driver session of the session
+// they were obtained from and can't change their causal consistency.
+func (s *Session) SetCausalConsistency(enabled bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	cluster := s.mongoCluster()
+	if s.shared {
+		panic("Session.SetCausalConsistency: sessions returned by WithContext can't change their causal consistency; use a copy instead")
+	}
+	if s.driverSession != nil && !s.causal {
+		panic("Session.SetCausalConsistency: sessions running a transaction can't change their causal consistency")
+	}
+	if enabled == s.causal && s.causalErr == nil {
+		return
+	}
+	s.endCausal()
+	if enabled {
+		s.driverSession, s.causalErr = startCausalSession(cluster, nil)
+		s.causal = true
+	}
+}
+
+// CausalConsistency returns whether the session is causally consistent.
+// See SetCausalConsistency.
+func (s *Session) CausalConsistency() bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.causal
+}
+
+// startCausalSession starts a causally consistent driver session on the
+// client of cluster, continuing from the cluster and operation times of
+// prev if it isn't nil.
+func startCausalSession(cluster *cluster, prev mongo.Session) (mongo.Session, error) {
+	sess, err := cluster.client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if clusterTime := prev.ClusterTime(); clusterTime != nil {
+			_ = sess.AdvanceClusterTime(clusterTime)
+		}
+		if operationTime := prev.OperationTime(); operationTime != nil {
+			_ = sess.AdvanceOperationTime(operationTime)
+		}
+	}
+	return sess, nil
+}
+
+// restartCausal moves the causally consistent driver session of the
+// session, if any, to the client of its current cluster. The caller must
+// hold the session lock.
+func (s *Session) restartCausal() {
+	if !s.causal {
+		return
+	}
+	prev := s.driverSession
+	s.driverSession, s.causalErr = startCausalSession(s.cluster, prev)
+	if prev != nil {
+		prev.EndSession(context.Background())
+	}
+}
+
+// endCausal ends the causally consistent driver session of the session, if
+// any. The caller must hold the session lock.
+func (s *Session) endCausal() {
+	if !s.causal {
+		return
+	}
+	if s.driverSession != nil {
+		s.driverSession.EndSession(context.Background())
+	}
+	s.driverSession = nil
+	s.causal = false
+	s.causalErr = nil
+}
@@ -0,0 +1,73 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestSession_SetCausalConsistency(t *testing.T) {
+	Convey("causally consistent sessions bind their own driver session", t, func() {
+		session := unreachableSession("mydb")
+		defer session.Close()
+
+		So(session.CausalConsistency(), ShouldBeFalse)
+		So(mongo.SessionFromContext(session.C("orders").opContext(nil)), ShouldBeNil)
+
+		session.SetCausalConsistency(true)
+		So(session.CausalConsistency(), ShouldBeTrue)
+		sess := mongo.SessionFromContext(session.C("orders").opContext(nil))
+		So(sess, ShouldNotBeNil)
+		So(mongo.SessionFromContext(session.DB("shop").opContext(context.Background())), ShouldEqual, sess)
+		So(mongo.SessionFromContext(session.WithContext(context.Background()).C("orders").opContext(nil)), ShouldEqual, sess)
+		So(func() { session.WithContext(context.Background()).SetCausalConsistency(false) }, ShouldPanic)
+
+		Convey("copies continue from the times of the original session", func() {
+			clusterTime, err := bson.Marshal(bson.D{{Key: "$clusterTime", Value: bson.D{{Key: "clusterTime", Value: primitive.Timestamp{T: 10, I: 1}}}}})
+			So(err, ShouldBeNil)
+			So(sess.AdvanceClusterTime(driverbson.Raw(clusterTime)), ShouldBeNil)
+			So(sess.AdvanceOperationTime(&primitive.Timestamp{T: 10, I: 1}), ShouldBeNil)
+
+			for _, scopy := range []*Session{session.Copy(), session.Clone(), session.New()} {
+				csess := mongo.SessionFromContext(scopy.C("orders").opContext(nil))
+				So(csess, ShouldNotBeNil)
+				So(csess, ShouldNotEqual, sess)
+				So(csess.ClusterTime(), ShouldResemble, sess.ClusterTime())
+				So(csess.OperationTime(), ShouldResemble, &primitive.Timestamp{T: 10, I: 1})
+				scopy.Close()
+			}
+		})
+
+		Convey("transactions run within the bound driver session", func() {
+			err := session.RunTransaction(func(tx *Session) error {
+				So(mongo.SessionFromContext(tx.C("orders").opContext(nil)), ShouldEqual, sess)
+				So(func() { tx.SetCausalConsistency(false) }, ShouldPanic)
+				So(tx.RunTransaction(func(*Session) error { return nil }, nil), ShouldNotBeNil)
+				return nil
+			}, nil)
+			So(err, ShouldBeNil)
+			So(mongo.SessionFromContext(session.C("orders").opContext(nil)), ShouldEqual, sess)
+		})
+
+		Convey("disabling unbinds the driver session", func() {
+			session.SetCausalConsistency(false)
+			So(session.CausalConsistency(), ShouldBeFalse)
+			So(mongo.SessionFromContext(session.C("orders").opContext(nil)), ShouldBeNil)
+		})
+	})
+
+	Convey("failures starting the driver session are reported by operations", t, func() {
+		session := unconnectedSession("mydb")
+		defer session.Close()
+
+		session.SetCausalConsistency(true)
+		So(session.C("orders").Find(nil).One(nil), ShouldEqual, mongo.ErrClientDisconnected)
+		So(session.Ping(), ShouldEqual, mongo.ErrClientDisconnected)
+		So(session.RunTransaction(func(*Session) error { return nil }, nil), ShouldEqual, mongo.ErrClientDisconnected)
+	})
+}
@@ -0,0 +1,281 @@
+package mgo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FullDocument controls the amount of data the server returns in the change
+// documents of updates.
+type FullDocument string
+
+const (
+	// Default returns the delta of the fields changed by updates.
+	Default FullDocument = "default"
+
+	// UpdateLookup also returns the most current majority-committed version
+	// of the documents updated.
+	UpdateLookup FullDocument = "updateLookup"
+)
+
+// ChangeStreamOptions holds the options of a change stream.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/method/db.collection.watch/
+type ChangeStreamOptions struct {
+	// FullDocument controls the amount of data that the server will return
+	// when returning a change document.
+	FullDocument FullDocument
+
+	// ResumeAfter specifies the logical starting point for the new change
+	// stream, as returned by ChangeStream.ResumeToken. The stream starts
+	// right after that change.
+	ResumeAfter *bson.Raw
+
+	// StartAfter works like ResumeAfter, also allowing the stream to start
+	// after an invalidate event. Requires MongoDB 4.2 or later.
+	StartAfter *bson.Raw
+
+	// StartAtOperationTime starts the stream at the changes that occurred
+	// at or after the given operation time. Requires MongoDB 4.0 or later.
+	StartAtOperationTime *bson.Timestamp
+
+	// MaxAwaitTimeMS specifies the maximum amount of time for the server
+	// to wait on new changes to satisfy a change stream query. Once it is
+	// exceeded, Next returns false and Timeout returns true. If zero, Next
+	// blocks until a change is available.
+	MaxAwaitTimeMS time.Duration
+
+	// BatchSize specifies the number of changes to return per batch.
+	// Defaults to the batch size of the session. See Session.SetBatch.
+	BatchSize int
+
+	// Collation specifies the way the server should collate returned data.
+	Collation *Collation
+}
+
+// driverOptions converts the options into their driver representation,
+// using batch as the batch size unless the options define one.
+func (o *ChangeStreamOptions) driverOptions(batch int) *options.ChangeStreamOptions {
+	opts := options.ChangeStream()
+	if o.FullDocument != "" {
+		opts.SetFullDocument(options.FullDocument(o.FullDocument))
+	}
+	if o.ResumeAfter != nil {
+		opts.SetResumeAfter(driverbson.Raw(o.ResumeAfter.Value))
+	}
+	if o.StartAfter != nil {
+		opts.SetStartAfter(driverbson.Raw(o.StartAfter.Value))
+	}
+	if o.StartAtOperationTime != nil {
+		opts.SetStartAtOperationTime(o.StartAtOperationTime)
+	}
+	if o.MaxAwaitTimeMS > 0 {
+		opts.SetMaxAwaitTime(o.MaxAwaitTimeMS)
+	}
+	if o.BatchSize > 0 {
+		batch = o.BatchSize
+	}
+	if batch > 0 {
+		opts.SetBatchSize(int32(batch))
+	}
+	if o.Collation != nil {
+		opts.SetCollation(*o.Collation)
+	}
+	return opts
+}
+
+// ChangeStream iterates over the changes of a collection, a database or a
+// whole deployment. It is created with Collection.Watch, Database.Watch or
+// Session.Watch, and resumes transparently after transient errors, such as
+// network errors and elections.
+type ChangeStream struct {
+	m        sync.Mutex
+	stream   *mongo.ChangeStream
+	base     context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	settings opSettings
+	limiter  *opLimiter
+	maxAwait time.Duration
+	timeout  bool
+	closed   bool
+	err      error
+}
+
+// watchFunc is the signature of the Watch methods of the driver clients,
+// databases and collections.
+type watchFunc func(context.Context, interface{}, ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+
+// Watch returns a change stream over the changes of the collection matching
+// pipeline, which must only hold stages supported by change streams, such
+// as $match and $project. Change streams require MongoDB 3.6 or later
+// running as a replica set or sharded cluster.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/changeStreams/
+func (c *Collection) Watch(pipeline interface{}, options ChangeStreamOptions) (*ChangeStream, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.db.watch(c.opContext(nil), c.collection.Watch, pipeline, &options)
+}
+
+// Watch returns a change stream over the changes of all the collections of
+// the database. Requires MongoDB 4.0 or later. See Collection.Watch.
+func (d *Database) Watch(pipeline interface{}, options ChangeStreamOptions) (*ChangeStream, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.watch(d.opContext(nil), d.database.Watch, pipeline, &options)
+}
+
+// Watch returns a change stream over the changes of all the databases of
+// the deployment, except for the admin, local and config databases.
+// Requires MongoDB 4.0 or later. See Collection.Watch.
+func (s *Session) Watch(pipeline interface{}, options ChangeStreamOptions) (*ChangeStream, error) {
+	db := s.DB("admin")
+	if db.err != nil {
+		return nil, db.err
+	}
+	return db.watch(db.opContext(nil), db.database.Client().Watch, pipeline, &options)
+}
+
+// watch starts a change stream with watch, fetching further changes with
+// ctx and the settings of the database.
+func (d *Database) watch(ctx context.Context, watch watchFunc, pipeline interface{}, opts *ChangeStreamOptions) (*ChangeStream, error) {
+	if pipeline == nil {
+		pipeline = bson.A{}
+	}
+	octx, done, err := d.settings.begin(ctx, d.limiter)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := watch(octx, pipeline, opts.driverOptions(d.settings.batch))
+	done()
+	if err != nil {
+		return nil, err
+	}
+	cs := &ChangeStream{
+		stream:   stream,
+		base:     ctx,
+		settings: d.settings,
+		limiter:  d.limiter,
+		maxAwait: opts.MaxAwaitTimeMS,
+	}
+	// Close cancels ctx to interrupt a blocked Next.
+	cs.ctx, cs.cancel = context.WithCancel(ctx)
+	return cs, nil
+}
+
+// Next retrieves the next change from the stream, returning true on
+// success and false once the stream is closed, failed, or no change came
+// within the MaxAwaitTimeMS option. Use Err and Timeout to tell these
+// cases apart. Next may be called again after a timeout.
+//
+// Next blocks until a change is available unless MaxAwaitTimeMS is set.
+// Calling Close from another goroutine interrupts it.
+func (cs *ChangeStream) Next(result interface{}) bool {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	cs.timeout = false
+	if cs.err != nil || cs.closed {
+		return false
+	}
+	for {
+		ctx, done, err := cs.settings.begin(cs.ctx, cs.limiter)
+		if err != nil {
+			cs.setErr(err)
+			return false
+		}
+		ok := cs.stream.TryNext(ctx)
+		done()
+		if ok {
+			cs.err = cs.stream.Decode(result)
+			return cs.err == nil
+		}
+		if err := cs.stream.Err(); err != nil {
+			cs.setErr(err)
+			return false
+		}
+		if cs.stream.ID() == 0 {
+			// The stream was invalidated.
+			return false
+		}
+		if cs.maxAwait > 0 {
+			cs.timeout = true
+			return false
+		}
+	}
+}
+
+// setErr records err as the error of the stream, unless it was caused by
+// Close interrupting Next. The caller must hold the stream lock.
+func (cs *ChangeStream) setErr(err error) {
+	if cs.ctx.Err() != nil && cs.base.Err() == nil {
+		return
+	}
+	cs.err = err
+}
+
+// Err returns nil if no errors happened during iteration, or the actual
+// error otherwise.
+func (cs *ChangeStream) Err() error {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	return cs.err
+}
+
+// Timeout returns true if the last call of Next returned false because no
+// change came within the MaxAwaitTimeMS option.
+func (cs *ChangeStream) Timeout() bool {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	return cs.timeout
+}
+
+// ResumeToken returns a copy of the token identifying the last change
+// returned by Next, to be used with the ResumeAfter or StartAfter options,
+// or nil if there is none yet.
+func (cs *ChangeStream) ResumeToken() *bson.Raw {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	token := cs.stream.ResumeToken()
+	if token == nil {
+		return nil
+	}
+	return &bson.Raw{Type: bsontype.EmbeddedDocument, Value: append([]byte(nil), token...)}
+}
+
+// Close kills the server cursor used by the stream, interrupting Next if
+// it is blocked in another goroutine, and returns the error of the stream,
+// if any.
+func (cs *ChangeStream) Close() error {
+	cs.cancel()
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	if cs.closed {
+		return cs.err
+	}
+	cs.closed = true
+	ctx, done, err := cs.settings.begin(cs.base, cs.limiter)
+	if err != nil {
+		// Kill the cursor anyway rather than leaving it to the server.
+		ctx, done = cs.base, func() {}
+	}
+	err = cs.stream.Close(ctx)
+	done()
+	if cs.err != nil {
+		return cs.err
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package mgo
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestChangeStreamOptions(t *testing.T) {
+	Convey("change stream options are mapped to driver options", t, func() {
+		opts := (&ChangeStreamOptions{}).driverOptions(0)
+		So(*opts.FullDocument, ShouldEqual, options.Default)
+		So(opts.ResumeAfter, ShouldBeNil)
+		So(opts.MaxAwaitTime, ShouldBeNil)
+		So(opts.BatchSize, ShouldBeNil)
+
+		opts = (&ChangeStreamOptions{}).driverOptions(50)
+		So(*opts.BatchSize, ShouldEqual, 50)
+
+		token, err := bson.Marshal(bson.D{{Key: "_data", Value: "8263"}})
+		So(err, ShouldBeNil)
+		opts = (&ChangeStreamOptions{
+			FullDocument:         UpdateLookup,
+			ResumeAfter:          &bson.Raw{Type: bsontype.EmbeddedDocument, Value: token},
+			StartAfter:           &bson.Raw{Type: bsontype.EmbeddedDocument, Value: token},
+			StartAtOperationTime: &bson.Timestamp{T: 10, I: 1},
+			MaxAwaitTimeMS:       time.Second,
+			BatchSize:            10,
+			Collation:            &Collation{Locale: "en"},
+		}).driverOptions(50)
+		So(*opts.FullDocument, ShouldEqual, options.UpdateLookup)
+		So(opts.ResumeAfter, ShouldResemble, driverbson.Raw(token))
+		So(opts.StartAfter, ShouldResemble, driverbson.Raw(token))
+		So(opts.StartAtOperationTime, ShouldResemble, &bson.Timestamp{T: 10, I: 1})
+		So(*opts.MaxAwaitTime, ShouldEqual, time.Second)
+		So(*opts.BatchSize, ShouldEqual, 10)
+		So(opts.Collation.Locale, ShouldEqual, "en")
+	})
+}
+
+// change is the part of change events the tests look at.
+type change struct {
+	OperationType string `bson:"operationType"`
+	FullDocument  struct {
+		Id int `bson:"_id"`
+	} `bson:"fullDocument"`
+}
+
+func TestChangeStream_Next(t *testing.T) {
+	ReplSetMongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("events")
+		So(coll.Create(&CollectionInfo{}), ShouldBeNil)
+
+		stream, err := coll.Watch(nil, ChangeStreamOptions{MaxAwaitTimeMS: 200 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(stream.ResumeToken(), ShouldBeNil)
+
+		// No change comes within MaxAwaitTimeMS.
+		var ev change
+		So(stream.Next(&ev), ShouldBeFalse)
+		So(stream.Timeout(), ShouldBeTrue)
+		So(stream.Err(), ShouldBeNil)
+
+		So(coll.Insert(M{"_id": 1}, M{"_id": 2}, M{"_id": 3}), ShouldBeNil)
+		So(stream.Next(&ev), ShouldBeTrue)
+		So(stream.Timeout(), ShouldBeFalse)
+		So(ev.OperationType, ShouldEqual, "insert")
+		So(ev.FullDocument.Id, ShouldEqual, 1)
+		token := stream.ResumeToken()
+		So(token, ShouldNotBeNil)
+		So(stream.Next(&ev), ShouldBeTrue)
+		So(ev.FullDocument.Id, ShouldEqual, 2)
+		So(stream.Close(), ShouldBeNil)
+		So(stream.Next(&ev), ShouldBeFalse)
+
+		// Streams resume after the change of a token.
+		resumed, err := coll.Watch(nil, ChangeStreamOptions{ResumeAfter: token, MaxAwaitTimeMS: 200 * time.Millisecond})
+		So(err, ShouldBeNil)
+		var ids []int
+		for resumed.Next(&ev) {
+			ids = append(ids, ev.FullDocument.Id)
+		}
+		So(resumed.Timeout(), ShouldBeTrue)
+		So(ids, ShouldResemble, []int{2, 3})
+		So(resumed.Close(), ShouldBeNil)
+	})
+}
+
+func TestChangeStream_Close(t *testing.T) {
+	ReplSetMongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("events")
+		So(coll.Create(&CollectionInfo{}), ShouldBeNil)
+
+		// Without MaxAwaitTimeMS, Next blocks until Close interrupts it.
+		stream, err := coll.Watch(nil, ChangeStreamOptions{})
+		So(err, ShouldBeNil)
+		next := make(chan bool)
+		go func() {
+			var ev change
+			next <- stream.Next(&ev)
+		}()
+		blocked := true
+		select {
+		case <-next:
+			blocked = false
+		case <-time.After(300 * time.Millisecond):
+		}
+		So(blocked, ShouldBeTrue)
+
+		So(stream.Close(), ShouldBeNil)
+		var ok, interrupted bool
+		select {
+		case ok = <-next:
+			interrupted = true
+		case <-time.After(5 * time.Second):
+		}
+		So(interrupted, ShouldBeTrue)
+		So(ok, ShouldBeFalse)
+		So(stream.Err(), ShouldBeNil)
+		So(stream.Timeout(), ShouldBeFalse)
+	})
+}
+
+func TestChangeStream_Invalidate(t *testing.T) {
+	ReplSetMongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("events")
+		So(coll.Insert(M{"_id": 1}), ShouldBeNil)
+
+		stream, err := coll.Watch(nil, ChangeStreamOptions{MaxAwaitTimeMS: 200 * time.Millisecond})
+		So(err, ShouldBeNil)
+		So(coll.DropCollection(), ShouldBeNil)
+
+		var ops []string
+		var ev change
+		for stream.Next(&ev) {
+			ops = append(ops, ev.OperationType)
+		}
+		So(ops, ShouldResemble, []string{"drop", "invalidate"})
+		So(stream.Timeout(), ShouldBeFalse)
+		So(stream.Err(), ShouldBeNil)
+		So(stream.Next(&ev), ShouldBeFalse)
+		So(stream.Close(), ShouldBeNil)
+	})
+}
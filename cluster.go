@@ -0,0 +1,194 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Masterminds/semver"
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cluster holds the driver client shared by a session and all the sessions
+// copied from it. It is reference counted, so the client is disconnected
+// once the last of those sessions is closed.
+type cluster struct {
+	client     *mongo.Client
+	options    *options.ClientOptions
+	owned      bool
+	topology   *topologyMonitor
+	m          sync.Mutex
+	references int
+
+	// root is the dialed cluster a login cluster was derived from, and
+	// logins holds the login clusters of a root cluster by credential.
+	root   *cluster
+	logins map[Credential]*cluster
+
+	// statsGeneration is the generation of the statistics the cluster is
+	// counted in, or zero. See countCluster.
+	statsGeneration int
+
+	// limiter limits the operations of the sessions of a root cluster and
+	// of its login clusters. See opLimiter.
+	limiter opLimiter
+
+	versionMu sync.Mutex
+	version   *semver.Version
+
+	// registry decodes the documents the driver doesn't, as those resumed
+	// with Collection.NewIter. It's the one of opts, or the default one.
+	registry *bsoncodec.Registry
+}
+
+// newCluster returns a cluster holding a single reference to client. When
+// owned is false the client belongs to the caller and is left connected
+// after the last release. The opts are those the client was created with,
+// or nil if unknown.
+func newCluster(client *mongo.Client, opts *options.ClientOptions, owned bool) *cluster {
+	generation := countCluster()
+	registry := driverbson.DefaultRegistry
+	if opts != nil && opts.Registry != nil {
+		registry = opts.Registry
+	}
+	return &cluster{client: client, options: opts, owned: owned, references: 1, registry: registry, statsGeneration: generation}
+}
+
+// connectCluster connects a new client with opts and returns an owned
+// cluster holding it, tracking its servers for Session.LiveServers.
+func connectCluster(ctx context.Context, opts *options.ClientOptions) (*cluster, error) {
+	topology := newTopologyMonitor()
+	client, err := mongo.Connect(ctx, monitorLog(monitorStats(topology.monitor(opts), topology)))
+	if err != nil {
+		return nil, err
+	}
+	c := newCluster(client, opts, true)
+	c.topology = topology
+	return c, nil
+}
+
+// Acquire increases the reference count for the cluster.
+func (c *cluster) Acquire() {
+	c.m.Lock()
+	c.references++
+	c.m.Unlock()
+}
+
+// Release decreases the reference count for the cluster. Once it reaches
+// zero the client is disconnected.
+func (c *cluster) Release() {
+	root := c.root
+	if root != nil {
+		// Hold the root lock so that login can't hand out the cluster
+		// while it is being released.
+		root.m.Lock()
+	}
+	c.m.Lock()
+	if c.references == 0 {
+		panic("cluster.Release() with references == 0")
+	}
+	c.references--
+	last := c.references == 0
+	c.m.Unlock()
+	if root != nil {
+		if last {
+			for cred, login := range root.logins {
+				if login == c {
+					delete(root.logins, cred)
+				}
+			}
+		}
+		root.m.Unlock()
+	}
+	if !last {
+		return
+	}
+	discountCluster(c.statsGeneration)
+	if c.owned {
+		_ = c.client.Disconnect(context.Background())
+	}
+	if root != nil {
+		root.Release()
+	}
+}
+
+// opLimiter returns the limiter of the operations run on c, shared with
+// the dialed cluster c was derived from.
+func (c *cluster) opLimiter() *opLimiter {
+	return &c.rootCluster().limiter
+}
+
+// rootCluster returns the dialed cluster c was derived from.
+func (c *cluster) rootCluster() *cluster {
+	if c.root != nil {
+		return c.root
+	}
+	return c
+}
+
+// login returns a referenced cluster authenticated with cred, connecting
+// a new client with the options of the dialed cluster if no session is
+// logged in with cred yet. The authentication is verified before
+// returning.
+func (c *cluster) login(ctx context.Context, cred *Credential) (*cluster, error) {
+	root := c.rootCluster()
+	root.m.Lock()
+	if login, ok := root.logins[*cred]; ok {
+		login.Acquire()
+		root.m.Unlock()
+		return login, nil
+	}
+	root.m.Unlock()
+
+	if root.options == nil {
+		return nil, errors.New("Session.Login: the client options of sessions created with NewFromMongoDriver are unknown")
+	}
+	auth, err := cred.auth("")
+	if err != nil {
+		return nil, err
+	}
+	login, err := connectCluster(ctx, options.MergeClientOptions(root.options).SetAuth(auth))
+	if err != nil {
+		return nil, err
+	}
+	if err = login.client.Ping(ctx, nil); err != nil {
+		login.Release()
+		return nil, err
+	}
+
+	root.m.Lock()
+	defer root.m.Unlock()
+	if other, ok := root.logins[*cred]; ok {
+		// Another session logged in with the same credential meanwhile.
+		login.Release()
+		other.Acquire()
+		return other, nil
+	}
+	if root.logins == nil {
+		root.logins = make(map[Credential]*cluster)
+	}
+	login.root = root
+	root.logins[*cred] = login
+	root.references++
+	return login, nil
+}
+
+// serverVersion returns the server version reported by buildInfo, running
+// the command the first time it is needed. Failures are not cached.
+func (c *cluster) serverVersion() *semver.Version {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.version == nil {
+		var info BuildInfo
+		err := c.client.Database("admin").RunCommand(context.Background(), bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+		if err != nil {
+			return nil
+		}
+		c.version, _ = semver.NewVersion(info.Version)
+	}
+	return c.version
+}
@@ -2,7 +2,6 @@ package mgo
 
 import (
 	"context"
-	"fmt"
 	"github.com/yaziming/mgo/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -15,10 +14,47 @@ type Collection struct {
 	collection *mongo.Collection
 	err        error
 	db         *Database
+	ctx        context.Context
+}
+
+// With returns a copy of c that uses session s. See Database.With.
+func (c *Collection) With(s *Session) *Collection {
+	return c.db.With(s).C(c.Name())
+}
+
+// WithContext returns a shallow copy of the collection bound to ctx. The
+// queries, pipes, bulks and iterators obtained from it run with ctx, as do
+// the methods that do not take an explicit context. See Session.WithContext.
+func (c *Collection) WithContext(ctx context.Context) *Collection {
+	if ctx == nil {
+		panic("nil context")
+	}
+	ccopy := *c
+	ccopy.ctx = ctx
+	return &ccopy
+}
+
+// opContext returns ctx, or the context bound to the collection if ctx is
+// nil, carrying the driver session of the database.
+func (c *Collection) opContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = contextOrBackground(c.ctx)
+	}
+	return withDriverSession(ctx, c.db.driverSession)
+}
+
+// begin starts an operation with opContext(ctx). See Database.begin.
+func (c *Collection) begin(ctx context.Context) (context.Context, func(), error) {
+	return c.db.settings.begin(c.opContext(ctx), c.db.limiter)
 }
 
 func (c *Collection) DropCollection() error {
-	return c.collection.Drop(nil)
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.collection.Drop(ctx)
 }
 
 // UpdateID updates a single document in the coll by id
@@ -31,14 +67,12 @@ func (c *Collection) Find(filter interface{}) *Query {
 	if filter == nil {
 		filter = bson.D{}
 	}
+	q := newQuery(c.db.settings)
+	q.op.filter = filter
 	return &Query{
-		query: query{
-			op: op{
-				filter: filter,
-			},
-		},
-		coll: c,
-		err:  c.err,
+		query: q,
+		coll:  c,
+		err:   c.err,
 	}
 }
 
@@ -53,7 +87,15 @@ func (c *Collection) InsertCtx(ctx context.Context, documents ...interface{}) (e
 
 // InsertAllWithResult inserts the provided documents and returns insert many result.
 func (c *Collection) InsertCtxWithResult(ctx context.Context, documents ...interface{}) (result *mongo.InsertManyResult, err error) {
+	ctx, done, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	result, err = c.collection.InsertMany(ctx, documents)
+	if err == mongo.ErrUnacknowledgedWrite {
+		err = nil
+	}
 	return
 }
 func (c *Collection) Update(selector interface{}, update interface{}) (err error) {
@@ -80,7 +122,7 @@ func (c *Collection) UpdateId(id interface{}, update interface{}) error {
 	return c.Update(bson.M{"_id": queryID(id)}, update)
 }
 func (c *Collection) Upsert(selector interface{}, update interface{}) (info *ChangeInfo, err error) {
-	r, err := c.UpdateOneCtxWithResult(context.Background(), selector, update, true)
+	r, err := c.UpdateOneCtxWithResult(nil, selector, update, true)
 	if err != nil {
 		return
 	}
@@ -103,8 +145,13 @@ func (c *Collection) UpdateAllCtxWithResult(ctx context.Context, selector interf
 		opt.SetUpsert(upsert[0])
 	}
 
+	ctx, done, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	var updateResult *mongo.UpdateResult
-	if updateResult, err = c.collection.UpdateMany(ctx, selector, update, opt); err != nil {
+	if updateResult, err = c.collection.UpdateMany(ctx, selector, update, opt); err != nil && err != mongo.ErrUnacknowledgedWrite {
 		return updateResult, err
 	}
 	return updateResult, nil
@@ -122,7 +169,15 @@ func (c *Collection) ReplaceOneCtxWithResult(ctx context.Context, selector inter
 	if len(upsert) > 0 {
 		opt.SetUpsert(upsert[0])
 	}
+	ctx, done, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	result, err = c.collection.ReplaceOne(ctx, selector, update, opt)
+	if err == mongo.ErrUnacknowledgedWrite {
+		return result, nil
+	}
 	if err != nil {
 		return
 	}
@@ -154,7 +209,15 @@ func (c *Collection) UpdateOneCtxWithResult(ctx context.Context, selector interf
 	if len(upsert) > 0 {
 		opt.SetUpsert(upsert[0])
 	}
+	ctx, done, err := c.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	result, err = c.collection.UpdateOne(ctx, selector, update, opt)
+	if err == mongo.ErrUnacknowledgedWrite {
+		return result, nil
+	}
 	if err != nil {
 		return
 	}
@@ -174,7 +237,12 @@ func (c *Collection) Remove(selector interface{}) (err error) {
 	if selector == nil {
 		selector = bson.D{}
 	}
-	if _, err = c.collection.DeleteOne(nil, selector); err != nil {
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, err = c.collection.DeleteOne(ctx, selector); err != nil && err != mongo.ErrUnacknowledgedWrite {
 		return err
 	}
 	return nil
@@ -186,7 +254,15 @@ func (c *Collection) RemoveAll(selector interface{}) (info *ChangeInfo, err erro
 	if selector == nil {
 		selector = bson.D{}
 	}
-	mResult, err := c.collection.DeleteMany(nil, selector)
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	mResult, err := c.collection.DeleteMany(ctx, selector)
+	if err == mongo.ErrUnacknowledgedWrite {
+		return &ChangeInfo{}, nil
+	}
 	if err != nil {
 		return
 	}
@@ -204,22 +280,40 @@ func (c *Collection) CountBy(selector interface{}) (count int, err error) {
 	if selector == nil {
 		selector = bson.D{}
 	}
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
 	var count64 int64
-	count64, err = c.collection.CountDocuments(context.TODO(), selector)
+	count64, err = c.collection.CountDocuments(ctx, selector)
 	return int(count64), err
 }
 func (c *Collection) Pipe(pipeline interface{}) *Pipe {
-	return &Pipe{pipeline: pipeline, coll: c}
+	return &Pipe{pipeline: pipeline, query: newQuery(c.db.settings), coll: c}
 }
 
 func (c *Collection) EnsureIndex(index Index) (err error) {
 
 	models, err := index.ToIndexModels()
-	_, err = c.collection.Indexes().CreateOne(context.TODO(), models)
+	if err != nil {
+		return err
+	}
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = c.collection.Indexes().CreateOne(ctx, models)
 	return err
 }
 func (c *Collection) DropAllIndexes() (err error) {
-	_, err = c.collection.Indexes().DropAll(nil)
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = c.collection.Indexes().DropAll(ctx)
 	return
 }
 func (c *Collection) DropIndex(key ...string) (err error) {
@@ -244,7 +338,12 @@ func (c *Collection) DropIndex(key ...string) (err error) {
 	return c.DropIndexName(name)
 }
 func (c *Collection) DropIndexName(name string) error {
-	_, err := c.collection.Indexes().DropOne(nil, name)
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = c.collection.Indexes().DropOne(ctx, name)
 	return err
 }
 func (c *Collection) EnsureIndexKey(key ...string) (err error) {
@@ -253,35 +352,40 @@ func (c *Collection) EnsureIndexKey(key ...string) (err error) {
 	})
 }
 
+// Create explicitly creates the c collection with details of info.
+// MongoDB creates collections automatically on use, so this method is only
+// necessary when creating collection with non-default characteristics,
+// such as capped, time-series or clustered collections. See
+// Database.CreateView for views.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/method/db.createCollection/
 func (c *Collection) Create(info *CollectionInfo) error {
-	opts := options.CreateCollection()
-
-	if info.Capped {
-		if info.MaxBytes < 1 {
-			return fmt.Errorf("Collection.Create: with Capped, MaxBytes must also be set")
-		}
-		opts.SetCapped(true).SetSizeInBytes(int64(info.MaxBytes))
-		if info.MaxDocs > 0 {
-			opts.SetMaxDocuments(int64(info.MaxDocs))
-		}
-	}
-
-	if info.Validator != nil {
-		opts.SetValidator(info.Validator)
-	}
-	if info.ValidationLevel != "" {
-		opts.SetValidationLevel(info.ValidationLevel)
-	}
-	if info.ValidationAction != "" {
-		opts.SetValidationAction(info.ValidationAction)
-	}
-	if info.StorageEngine != nil {
-		opts.SetStorageEngine(info.StorageEngine)
+	cmd, err := info.createCommand(c.Name())
+	if err != nil {
+		return err
 	}
-	if info.Collation != nil {
-		opts.SetCollation(info.Collation)
+	return c.db.runWriteCommand(cmd)
+}
+
+// Rename renames the collection to newName, dropping any collection
+// already named newName first if dropTarget is set. NewName is the name of
+// the collection in the same database unless qualified by the name of
+// another database, as in "otherdb.coll", to move the collection across
+// databases. Names holding dots must always be qualified.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/command/renameCollection/
+func (c *Collection) Rename(newName string, dropTarget bool) error {
+	target := c.db.qualifiedC(newName)
+	cmd := bson.D{
+		{Key: "renameCollection", Value: c.FullName()},
+		{Key: "to", Value: target.FullName()},
+		{Key: "dropTarget", Value: dropTarget},
 	}
-	return c.collection.Database().CreateCollection(context.Background(), c.collection.Name(), opts)
+	return c.db.sibling("admin").runWriteCommand(cmd)
 }
 
 func (c *Collection) Bulk() *Bulk {
@@ -295,3 +399,14 @@ func (c *Collection) UpsertId(i interface{}, doc bson.M) (*ChangeInfo, error) {
 func (c *Collection) Database() *Database {
 	return c.db
 }
+
+// Name returns the name of the collection.
+func (c *Collection) Name() string {
+	return c.collection.Name()
+}
+
+// FullName returns the name of the collection qualified by the name of its
+// database, as in "mydb.mycoll".
+func (c *Collection) FullName() string {
+	return c.db.Name() + "." + c.collection.Name()
+}
@@ -1,5 +1,13 @@
 package mgo
 
+import (
+	"errors"
+	"time"
+
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
 type CollectionInfo struct {
 	// DisableIdIndex prevents the automatic creation of the index
 	// on the _id field for the coll.
@@ -43,4 +51,392 @@ type CollectionInfo struct {
 	// Collation allows users to specify language-specific rules for string
 	// comparison, such as rules for lettercase and accent marks.
 	Collation *Collation
+
+	// TimeSeries makes the collection a time-series collection. Requires
+	// MongoDB 5.0 or later.
+	TimeSeries *TimeSeriesInfo
+
+	// ExpireAfter makes the server delete the documents of time-series
+	// and clustered collections once they are older than the given delta.
+	ExpireAfter time.Duration
+
+	// Clustered makes the collection clustered by _id, storing documents
+	// in the order of the _id index. Requires MongoDB 5.3 or later.
+	Clustered bool
+
+	// ChangeStreamPreAndPostImages makes change streams able to report
+	// the documents as they were before and after each change. Requires
+	// MongoDB 6.0 or later.
+	ChangeStreamPreAndPostImages bool
+
+	// IndexOptionDefaults allows specifying the default storage engine
+	// options of the indexes of the collection, as StorageEngine does for
+	// the collection itself.
+	IndexOptionDefaults interface{}
+}
+
+// TimeSeriesInfo holds the options of time-series collections.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/core/timeseries-collections/
+type TimeSeriesInfo struct {
+	// TimeField is the name of the field holding the date of each
+	// measurement. It's required.
+	TimeField string
+
+	// MetaField is the name of the field holding the metadata identifying
+	// the source of the measurements, if any.
+	MetaField string
+
+	// Granularity may be set to "seconds" (the default), "minutes" or
+	// "hours" to match the interval between consecutive measurements of
+	// a same source.
+	Granularity string
+}
+
+// createCommand returns the create command for the collection named name.
+func (info *CollectionInfo) createCommand(name string) (bson.D, error) {
+	cmd := bson.D{{Key: "create", Value: name}}
+	if info.Capped {
+		if info.MaxBytes < 1 {
+			return nil, errors.New("Collection.Create: with Capped, MaxBytes must also be set")
+		}
+		cmd = append(cmd, bson.E{Key: "capped", Value: true}, bson.E{Key: "size", Value: int64(info.MaxBytes)})
+		if info.MaxDocs > 0 {
+			cmd = append(cmd, bson.E{Key: "max", Value: int64(info.MaxDocs)})
+		}
+	}
+	if info.TimeSeries != nil {
+		if info.TimeSeries.TimeField == "" {
+			return nil, errors.New("Collection.Create: with TimeSeries, TimeField must also be set")
+		}
+		ts := bson.D{{Key: "timeField", Value: info.TimeSeries.TimeField}}
+		if info.TimeSeries.MetaField != "" {
+			ts = append(ts, bson.E{Key: "metaField", Value: info.TimeSeries.MetaField})
+		}
+		if info.TimeSeries.Granularity != "" {
+			ts = append(ts, bson.E{Key: "granularity", Value: info.TimeSeries.Granularity})
+		}
+		cmd = append(cmd, bson.E{Key: "timeseries", Value: ts})
+	}
+	if info.Clustered {
+		cmd = append(cmd, bson.E{Key: "clusteredIndex", Value: bson.D{
+			{Key: "key", Value: bson.D{{Key: "_id", Value: 1}}},
+			{Key: "unique", Value: true},
+		}})
+	}
+	if info.ExpireAfter > 0 {
+		cmd = append(cmd, bson.E{Key: "expireAfterSeconds", Value: int64(info.ExpireAfter / time.Second)})
+	}
+	if info.Validator != nil {
+		cmd = append(cmd, bson.E{Key: "validator", Value: info.Validator})
+	}
+	if info.ValidationLevel != "" {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: info.ValidationLevel})
+	}
+	if info.ValidationAction != "" {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: info.ValidationAction})
+	}
+	if info.StorageEngine != nil {
+		cmd = append(cmd, bson.E{Key: "storageEngine", Value: info.StorageEngine})
+	}
+	if info.IndexOptionDefaults != nil {
+		cmd = append(cmd, bson.E{Key: "indexOptionDefaults", Value: bson.D{{Key: "storageEngine", Value: info.IndexOptionDefaults}}})
+	}
+	if info.Collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: info.Collation.ToDocument()})
+	}
+	if info.ChangeStreamPreAndPostImages {
+		cmd = append(cmd, bson.E{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}})
+	}
+	return cmd, nil
+}
+
+// CreateView creates a view named name over the source collection or view,
+// holding the documents output by pipeline. If collation is not nil, it is
+// the default collation of the view. Requires MongoDB 3.4 or later.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/core/views/
+func (d *Database) CreateView(name, source string, pipeline interface{}, collation *Collation) error {
+	if pipeline == nil {
+		pipeline = bson.A{}
+	}
+	cmd := bson.D{{Key: "create", Value: name}, {Key: "viewOn", Value: source}, {Key: "pipeline", Value: pipeline}}
+	if collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: collation.ToDocument()})
+	}
+	return d.runWriteCommand(cmd)
+}
+
+// runWriteCommand runs the command cmd with the write concern of d.
+func (d *Database) runWriteCommand(cmd bson.D) error {
+	if wc := d.database.WriteConcern(); !d.inTransaction() && writeconcern.AckWrite(wc) &&
+		(wc.GetW() != nil || wc.GetJ() || wc.GetWTimeout() != 0) {
+		cmd = append(cmd, bson.E{Key: "writeConcern", Value: wc})
+	}
+	return d.Run(cmd, nil)
+}
+
+// inTransaction reports whether the operations of d run within a
+// transaction, where commands can't set a write concern.
+func (d *Database) inTransaction() bool {
+	if d.driverSession == nil {
+		return false
+	}
+	d.session.m.RLock()
+	defer d.session.m.RUnlock()
+	return !d.session.causal
+}
+
+// CollectionSpec describes a collection of a database. See
+// Database.CollectionInfos.
+type CollectionSpec struct {
+	// Name is the name of the collection.
+	Name string
+
+	// Type is "collection" for regular collections, or "view" or
+	// "timeseries" for the collections of these kinds.
+	Type string
+
+	// ReadOnly reports whether the collection is read-only, as views are.
+	ReadOnly bool
+
+	// Info holds the options the collection was created with.
+	Info CollectionInfo
+
+	// ViewOn and Pipeline hold the source and the pipeline of views.
+	// See Database.CreateView.
+	ViewOn   string
+	Pipeline interface{}
+}
+
+// collectionSpec is a collection as reported by listCollections.
+type collectionSpec struct {
+	Name    string `bson:"name"`
+	Type    string `bson:"type"`
+	Options struct {
+		Capped           bool           `bson:"capped"`
+		Size             int            `bson:"size"`
+		Max              int            `bson:"max"`
+		Validator        interface{}    `bson:"validator"`
+		ValidationLevel  string         `bson:"validationLevel"`
+		ValidationAction string         `bson:"validationAction"`
+		StorageEngine    interface{}    `bson:"storageEngine"`
+		Collation        *collationSpec `bson:"collation"`
+		ViewOn           string         `bson:"viewOn"`
+		Pipeline         interface{}    `bson:"pipeline"`
+		TimeSeries       *struct {
+			TimeField   string `bson:"timeField"`
+			MetaField   string `bson:"metaField"`
+			Granularity string `bson:"granularity"`
+		} `bson:"timeseries"`
+		ExpireAfterSeconds int64       `bson:"expireAfterSeconds"`
+		ClusteredIndex     interface{} `bson:"clusteredIndex"`
+		PrePostImages      struct {
+			Enabled bool `bson:"enabled"`
+		} `bson:"changeStreamPreAndPostImages"`
+		IndexOptionDefaults struct {
+			StorageEngine interface{} `bson:"storageEngine"`
+		} `bson:"indexOptionDefaults"`
+	} `bson:"options"`
+	Info struct {
+		ReadOnly bool `bson:"readOnly"`
+	} `bson:"info"`
+	IdIndex interface{} `bson:"idIndex"`
+}
+
+// collationSpec is a collation as reported by the server. Collation can't
+// be decoded directly, as the driver expects its fields in lowercase.
+type collationSpec struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel"`
+	CaseFirst       string `bson:"caseFirst"`
+	Strength        int    `bson:"strength"`
+	NumericOrdering bool   `bson:"numericOrdering"`
+	Alternate       string `bson:"alternate"`
+	MaxVariable     string `bson:"maxVariable"`
+	Normalization   bool   `bson:"normalization"`
+	Backwards       bool   `bson:"backwards"`
+}
+
+func (spec *collationSpec) collation() *Collation {
+	if spec == nil {
+		return nil
+	}
+	return &Collation{
+		Locale:          spec.Locale,
+		CaseLevel:       spec.CaseLevel,
+		CaseFirst:       spec.CaseFirst,
+		Strength:        spec.Strength,
+		NumericOrdering: spec.NumericOrdering,
+		Alternate:       spec.Alternate,
+		MaxVariable:     spec.MaxVariable,
+		Normalization:   spec.Normalization,
+		Backwards:       spec.Backwards,
+	}
+}
+
+func (spec *collectionSpec) collectionSpec() CollectionSpec {
+	opts := &spec.Options
+	result := CollectionSpec{
+		Name:     spec.Name,
+		Type:     spec.Type,
+		ReadOnly: spec.Info.ReadOnly,
+		ViewOn:   opts.ViewOn,
+		Pipeline: opts.Pipeline,
+		Info: CollectionInfo{
+			ForceIdIndex:     opts.Capped && spec.IdIndex != nil,
+			Capped:           opts.Capped,
+			MaxBytes:         opts.Size,
+			MaxDocs:          opts.Max,
+			Validator:        opts.Validator,
+			ValidationLevel:  opts.ValidationLevel,
+			ValidationAction: opts.ValidationAction,
+			StorageEngine:    opts.StorageEngine,
+			Collation:        opts.Collation.collation(),
+
+			ExpireAfter:                  time.Duration(opts.ExpireAfterSeconds) * time.Second,
+			ChangeStreamPreAndPostImages: opts.PrePostImages.Enabled,
+			IndexOptionDefaults:          opts.IndexOptionDefaults.StorageEngine,
+		},
+	}
+	if ts := opts.TimeSeries; ts != nil {
+		result.Info.TimeSeries = &TimeSeriesInfo{TimeField: ts.TimeField, MetaField: ts.MetaField, Granularity: ts.Granularity}
+	}
+	// Clustered collections report their index, time-series collections
+	// report true as they are clustered internally.
+	if clustered, ok := opts.ClusteredIndex.(bool); ok {
+		result.Info.Clustered = clustered && opts.TimeSeries == nil
+	} else {
+		result.Info.Clustered = opts.ClusteredIndex != nil
+	}
+	return result
+}
+
+// CollectionInfos returns the collections of the database matching filter,
+// which applies to the documents returned by the listCollections command,
+// such as bson.M{"type": "view"}. A nil filter matches all the collections.
+func (d *Database) CollectionInfos(filter interface{}) ([]CollectionSpec, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	ctx, done, err := d.begin(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	cursor, err := d.database.ListCollections(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var specs []collectionSpec
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	result := make([]CollectionSpec, len(specs))
+	for i := range specs {
+		result[i] = specs[i].collectionSpec()
+	}
+	return result, nil
+}
+
+// Info returns the options the collection was created with, or ErrNotFound
+// if the collection doesn't exist. See Collection.Create.
+func (c *Collection) Info() (*CollectionInfo, error) {
+	spec, err := c.spec()
+	if err != nil {
+		return nil, err
+	}
+	return &spec.Info, nil
+}
+
+// spec returns the description of the collection, or ErrNotFound if it
+// doesn't exist.
+func (c *Collection) spec() (*CollectionSpec, error) {
+	specs, err := c.db.CollectionInfos(bson.D{{Key: "name", Value: c.Name()}})
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &specs[0], nil
+}
+
+// CollectionModification holds the changes applied by Collection.Modify.
+// The fields left unset are not changed.
+type CollectionModification struct {
+	// Validator replaces the validation expression of the collection. An
+	// empty document, such as bson.M{}, removes it. See CollectionInfo.
+	Validator interface{}
+
+	// ValidationLevel and ValidationAction change how the validator is
+	// applied. See CollectionInfo.
+	ValidationLevel  string
+	ValidationAction string
+
+	// Index changes an index of the collection.
+	Index *IndexModification
+}
+
+// IndexModification holds the changes applied to an existing index by
+// Collection.Modify.
+type IndexModification struct {
+	// Name or Key identifies the index. See Index.
+	Name string
+	Key  []string
+
+	// ExpireAfter changes the time after which the documents of a TTL
+	// index expire, if not nil.
+	ExpireAfter *time.Duration
+
+	// Hidden changes whether the index is hidden from the query planner,
+	// if not nil. Requires MongoDB 4.4 or later.
+	Hidden *bool
+}
+
+// Modify applies mod to the collection with the collMod command, which
+// makes rolling out schema changes idempotent, as applying the same
+// modification again has no effect.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/command/collMod/
+func (c *Collection) Modify(mod *CollectionModification) error {
+	cmd := bson.D{{Key: "collMod", Value: c.Name()}}
+	if mod.Validator != nil {
+		cmd = append(cmd, bson.E{Key: "validator", Value: mod.Validator})
+	}
+	if mod.ValidationLevel != "" {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: mod.ValidationLevel})
+	}
+	if mod.ValidationAction != "" {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: mod.ValidationAction})
+	}
+	if index := mod.Index; index != nil {
+		var doc bson.D
+		switch {
+		case index.Name != "":
+			doc = bson.D{{Key: "name", Value: index.Name}}
+		case len(index.Key) > 0:
+			keyInfo, err := parseIndexKey(index.Key)
+			if err != nil {
+				return err
+			}
+			doc = bson.D{{Key: "keyPattern", Value: keyInfo.key}}
+		default:
+			return errors.New("Collection.Modify: index modification requires Name or Key")
+		}
+		if index.ExpireAfter != nil {
+			doc = append(doc, bson.E{Key: "expireAfterSeconds", Value: int64(*index.ExpireAfter / time.Second)})
+		}
+		if index.Hidden != nil {
+			doc = append(doc, bson.E{Key: "hidden", Value: *index.Hidden})
+		}
+		cmd = append(cmd, bson.E{Key: "index", Value: doc})
+	}
+	return c.db.Run(cmd, nil)
 }
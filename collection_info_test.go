@@ -0,0 +1,189 @@
+package mgo
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestCollectionSpec(t *testing.T) {
+	Convey("listCollections results are converted to collection infos", t, func() {
+		data, err := bson.Marshal(bson.D{
+			{Key: "name", Value: "events"},
+			{Key: "type", Value: "collection"},
+			{Key: "options", Value: bson.D{
+				{Key: "capped", Value: true},
+				{Key: "size", Value: 4096},
+				{Key: "max", Value: 10},
+				{Key: "validator", Value: bson.D{{Key: "n", Value: bson.D{{Key: "$gte", Value: 0}}}}},
+				{Key: "validationLevel", Value: "moderate"},
+				{Key: "validationAction", Value: "warn"},
+				{Key: "collation", Value: bson.D{{Key: "locale", Value: "fr"}, {Key: "caseLevel", Value: true}, {Key: "strength", Value: 2}}},
+			}},
+			{Key: "info", Value: bson.D{{Key: "readOnly", Value: false}}},
+			{Key: "idIndex", Value: bson.D{{Key: "name", Value: "_id_"}}},
+		})
+		So(err, ShouldBeNil)
+		var spec collectionSpec
+		So(bson.Unmarshal(data, &spec), ShouldBeNil)
+		So(spec.collectionSpec(), ShouldResemble, CollectionSpec{
+			Name: "events",
+			Type: "collection",
+			Info: CollectionInfo{
+				ForceIdIndex:     true,
+				Capped:           true,
+				MaxBytes:         4096,
+				MaxDocs:          10,
+				Validator:        bson.D{{Key: "n", Value: bson.D{{Key: "$gte", Value: int32(0)}}}},
+				ValidationLevel:  "moderate",
+				ValidationAction: "warn",
+				Collation:        &Collation{Locale: "fr", CaseLevel: true, Strength: 2},
+			},
+		})
+	})
+}
+
+func TestCollection_Info(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("mydb")
+		coll := db.C("events")
+		_, err := coll.Info()
+		So(err, ShouldEqual, ErrNotFound)
+
+		So(coll.Create(&CollectionInfo{
+			Validator:       bson.M{"n": bson.M{"$gte": 0}},
+			ValidationLevel: "strict",
+			Collation:       &Collation{Locale: "fr"},
+		}), ShouldBeNil)
+		So(db.C("other").Create(&CollectionInfo{Capped: true, MaxBytes: 4096}), ShouldBeNil)
+
+		info, err := coll.Info()
+		So(err, ShouldBeNil)
+		So(info.Validator, ShouldResemble, bson.D{{Key: "n", Value: bson.D{{Key: "$gte", Value: int32(0)}}}})
+		So(info.ValidationLevel, ShouldEqual, "strict")
+		So(info.Collation.Locale, ShouldEqual, "fr")
+
+		specs, err := db.CollectionInfos(bson.M{"options.capped": true})
+		So(err, ShouldBeNil)
+		So(specs, ShouldHaveLength, 1)
+		So(specs[0].Name, ShouldEqual, "other")
+		So(specs[0].Type, ShouldEqual, "collection")
+		So(specs[0].Info.MaxBytes, ShouldEqual, 4096)
+		specs, err = db.CollectionInfos(nil)
+		So(err, ShouldBeNil)
+		So(specs, ShouldHaveLength, 2)
+
+		mod := &CollectionModification{Validator: bson.M{"n": bson.M{"$gte": 1}}, ValidationAction: "warn"}
+		So(coll.Modify(mod), ShouldBeNil)
+		So(coll.Modify(mod), ShouldBeNil)
+		info, err = coll.Info()
+		So(err, ShouldBeNil)
+		So(info.Validator, ShouldResemble, bson.D{{Key: "n", Value: bson.D{{Key: "$gte", Value: int32(1)}}}})
+		So(info.ValidationAction, ShouldEqual, "warn")
+
+		So(coll.EnsureIndex(Index{Key: []string{"time"}, ExpireAfter: time.Hour}), ShouldBeNil)
+		expireAfter := time.Minute
+		So(coll.Modify(&CollectionModification{Index: &IndexModification{Key: []string{"time"}, ExpireAfter: &expireAfter}}), ShouldBeNil)
+		indexes, err := coll.Indexes()
+		So(err, ShouldBeNil)
+		So(indexes[1].ExpireAfter, ShouldEqual, time.Minute)
+		So(coll.Modify(&CollectionModification{Index: &IndexModification{}}), ShouldNotBeNil)
+	})
+}
+
+func TestCollectionInfo_CreateCommand(t *testing.T) {
+	Convey("collection infos are converted to create commands", t, func() {
+		cmd, err := (&CollectionInfo{}).createCommand("events")
+		So(err, ShouldBeNil)
+		So(cmd, ShouldResemble, bson.D{{Key: "create", Value: "events"}})
+
+		cmd, err = (&CollectionInfo{
+			TimeSeries:                   &TimeSeriesInfo{TimeField: "ts", MetaField: "source", Granularity: "minutes"},
+			ExpireAfter:                  24 * time.Hour,
+			ChangeStreamPreAndPostImages: true,
+			IndexOptionDefaults:          bson.M{"wiredTiger": bson.M{}},
+		}).createCommand("metrics")
+		So(err, ShouldBeNil)
+		So(cmd, ShouldResemble, bson.D{
+			{Key: "create", Value: "metrics"},
+			{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}, {Key: "metaField", Value: "source"}, {Key: "granularity", Value: "minutes"}}},
+			{Key: "expireAfterSeconds", Value: int64(86400)},
+			{Key: "indexOptionDefaults", Value: bson.D{{Key: "storageEngine", Value: bson.M{"wiredTiger": bson.M{}}}}},
+			{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}},
+		})
+
+		cmd, err = (&CollectionInfo{Clustered: true, Capped: true, MaxBytes: 4096, MaxDocs: 10}).createCommand("events")
+		So(err, ShouldBeNil)
+		So(cmd, ShouldResemble, bson.D{
+			{Key: "create", Value: "events"},
+			{Key: "capped", Value: true},
+			{Key: "size", Value: int64(4096)},
+			{Key: "max", Value: int64(10)},
+			{Key: "clusteredIndex", Value: bson.D{{Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "unique", Value: true}}},
+		})
+
+		_, err = (&CollectionInfo{Capped: true}).createCommand("events")
+		So(err, ShouldNotBeNil)
+		_, err = (&CollectionInfo{TimeSeries: &TimeSeriesInfo{}}).createCommand("metrics")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("time-series, clustered and view options are read back", t, func() {
+		decode := func(doc bson.D) CollectionSpec {
+			data, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			var spec collectionSpec
+			So(bson.Unmarshal(data, &spec), ShouldBeNil)
+			return spec.collectionSpec()
+		}
+		spec := decode(bson.D{{Key: "name", Value: "metrics"}, {Key: "type", Value: "timeseries"}, {Key: "options", Value: bson.D{
+			{Key: "expireAfterSeconds", Value: int64(60)},
+			{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "ts"}, {Key: "granularity", Value: "seconds"}}},
+			{Key: "clusteredIndex", Value: true},
+		}}})
+		So(spec.Info.TimeSeries, ShouldResemble, &TimeSeriesInfo{TimeField: "ts", Granularity: "seconds"})
+		So(spec.Info.ExpireAfter, ShouldEqual, time.Minute)
+		So(spec.Info.Clustered, ShouldBeFalse)
+
+		spec = decode(bson.D{{Key: "name", Value: "events"}, {Key: "options", Value: bson.D{
+			{Key: "clusteredIndex", Value: bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}}},
+			{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}},
+		}}})
+		So(spec.Info.Clustered, ShouldBeTrue)
+		So(spec.Info.ChangeStreamPreAndPostImages, ShouldBeTrue)
+
+		spec = decode(bson.D{{Key: "name", Value: "recent"}, {Key: "type", Value: "view"}, {Key: "options", Value: bson.D{
+			{Key: "viewOn", Value: "events"},
+			{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "n", Value: 1}}}}}},
+		}}, {Key: "info", Value: bson.D{{Key: "readOnly", Value: true}}}})
+		So(spec.ViewOn, ShouldEqual, "events")
+		So(spec.Pipeline, ShouldResemble, bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "n", Value: int32(1)}}}}})
+		So(spec.ReadOnly, ShouldBeTrue)
+	})
+}
+
+func TestDatabase_CreateView(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("mydb")
+		So(db.C("events").Insert(M{"_id": 1, "n": 1}, M{"_id": 2, "n": 2}), ShouldBeNil)
+		So(db.CreateView("big", "events", []M{{"$match": M{"n": M{"$gt": 1}}}}, nil), ShouldBeNil)
+
+		var docs []M
+		So(db.C("big").Find(nil).All(&docs), ShouldBeNil)
+		So(docs, ShouldHaveLength, 1)
+
+		specs, err := db.CollectionInfos(bson.M{"type": "view"})
+		So(err, ShouldBeNil)
+		So(specs, ShouldHaveLength, 1)
+		So(specs[0].Name, ShouldEqual, "big")
+		So(specs[0].ViewOn, ShouldEqual, "events")
+		So(specs[0].ReadOnly, ShouldBeTrue)
+
+		So(db.C("metrics").Create(&CollectionInfo{TimeSeries: &TimeSeriesInfo{TimeField: "ts", MetaField: "source"}}), ShouldBeNil)
+		info, err := db.C("metrics").Info()
+		So(err, ShouldBeNil)
+		So(info.TimeSeries.TimeField, ShouldEqual, "ts")
+		So(info.TimeSeries.MetaField, ShouldEqual, "source")
+	})
+}
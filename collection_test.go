@@ -1,7 +1,10 @@
 package mgo
 
 import (
+	"context"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"testing"
 	"time"
 )
@@ -134,3 +137,71 @@ func TestCollection_CreateCollectionCapped(t *testing.T) {
 		So(n, ShouldEqual, 3)
 	})
 }
+
+func TestCollection_WithContext(t *testing.T) {
+	Convey("operations use the context bound to the session or collection", t, func() {
+		root := unreachableSession("mydb")
+		defer root.Close()
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		session := root.WithContext(cancelled)
+		So(session.Context(), ShouldEqual, cancelled)
+		coll := session.DB("mydb").C("mycoll")
+		So(coll.Insert(M{"a": 1}), ShouldErrorMatche, ".*context canceled.*")
+		So(coll.Find(nil).One(nil), ShouldErrorMatche, ".*context canceled.*")
+		So(coll.Find(nil).Iter().Err(), ShouldErrorMatche, ".*context canceled.*")
+		So(coll.Pipe([]M{}).All(&[]M{}), ShouldErrorMatche, ".*context canceled.*")
+		_, err := coll.Count()
+		So(err, ShouldErrorMatche, ".*context canceled.*")
+		_, err = coll.Bulk().Run()
+		So(err, ShouldNotBeNil)
+		_, err = session.DB("mydb").CollectionNames()
+		So(err, ShouldErrorMatche, ".*context canceled.*")
+		_, err = session.DB("mydb").GridFS("fs").Open("file")
+		So(err, ShouldEqual, context.Canceled)
+
+		deadline, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = coll.WithContext(deadline).Find(nil).One(nil)
+		So(err, ShouldErrorMatche, ".*context deadline exceeded.*")
+		err = coll.WithContext(deadline).InsertCtx(cancelled, M{"a": 1})
+		So(err, ShouldErrorMatche, ".*context canceled.*")
+	})
+}
+
+type requestKey struct{}
+
+func TestCollection_With(t *testing.T) {
+	Convey("collections and databases take on the settings of another session", t, func() {
+		session := unreachableSession("mydb")
+		defer session.Close()
+		coll := session.DB("shop").C("orders")
+
+		other := session.Copy()
+		defer other.Close()
+		other.SetMode(Secondary, true)
+		other.SetSafe(&Safe{W: 2})
+		ctx := context.WithValue(context.Background(), requestKey{}, 1)
+		bound := other.WithContext(ctx)
+
+		c := coll.With(bound)
+		So(c.Database().Session(), ShouldEqual, bound)
+		So(c.FullName(), ShouldEqual, "shop.orders")
+		So(c.db.database.ReadPreference().Mode(), ShouldEqual, readpref.SecondaryMode)
+		So(c.db.database.WriteConcern().GetW(), ShouldEqual, 2)
+		So(c.opContext(nil).Value(requestKey{}), ShouldEqual, 1)
+		So(coll.opContext(nil).Value(requestKey{}), ShouldBeNil)
+
+		db := coll.Database().With(other)
+		So(db.Session(), ShouldEqual, other)
+		So(db.Name(), ShouldEqual, "shop")
+
+		err := session.RunTransaction(func(tx *Session) error {
+			So(mongo.SessionFromContext(coll.With(tx).opContext(nil)), ShouldNotBeNil)
+			So(mongo.SessionFromContext(coll.opContext(nil)), ShouldBeNil)
+			return nil
+		}, nil)
+		So(err, ShouldBeNil)
+	})
+}
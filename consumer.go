@@ -0,0 +1,346 @@
+package mgo
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// DefaultCheckpointEvery is the default number of changes handled by a
+	// Consumer between checkpoints. See ConsumerOptions.
+	DefaultCheckpointEvery = 100
+
+	// DefaultCheckpointInterval is the default time between the
+	// checkpoints of a Consumer. See ConsumerOptions.
+	DefaultCheckpointInterval = 5 * time.Second
+
+	// DefaultRetryDelay is the default time a Consumer waits before
+	// restarting its change stream after an error. See ConsumerOptions.
+	DefaultRetryDelay = time.Second
+)
+
+// changeStreamHistoryLost is the code of the server error reporting that
+// the resume point of a change stream is no longer in the oplog.
+const changeStreamHistoryLost = 286
+
+// ErrChangeStreamInvalidated is returned by Consumer.Run when the change
+// stream is invalidated, as happens when the watched collection is dropped
+// or renamed.
+var ErrChangeStreamInvalidated = errors.New("change stream invalidated")
+
+// ChangeEvent is a change delivered to the handler of a Consumer.
+type ChangeEvent struct {
+	// ResumeToken identifies the change within the change stream.
+	ResumeToken *bson.Raw `bson:"_id"`
+
+	// OperationType is the type of the change, such as "insert",
+	// "update", "replace" or "delete".
+	OperationType string `bson:"operationType"`
+
+	// DocumentKey holds the _id, and the shard key if any, of the document
+	// changed.
+	DocumentKey bson.D `bson:"documentKey"`
+
+	raw driverbson.Raw
+}
+
+// Unmarshal unmarshals the whole change document into result.
+func (e *ChangeEvent) Unmarshal(result interface{}) error {
+	return bson.Unmarshal(e.raw, result)
+}
+
+// ChangeHandler handles a change delivered by a Consumer.
+type ChangeHandler func(event *ChangeEvent) error
+
+// TokenStore persists the resume tokens of consumers. See Consumer.
+type TokenStore interface {
+	// Load returns the token last saved for the consumer named name, or
+	// nil if there is none.
+	Load(name string) (*bson.Raw, error)
+
+	// Save saves token as the one of the consumer named name.
+	Save(name string, token *bson.Raw) error
+}
+
+// collectionTokenStore is a TokenStore holding a document per consumer in
+// a collection.
+type collectionTokenStore struct {
+	c *Collection
+}
+
+// NewCollectionTokenStore returns a TokenStore saving the tokens in c, in
+// a document per consumer with the name of the consumer as _id.
+func NewCollectionTokenStore(c *Collection) TokenStore {
+	return &collectionTokenStore{c}
+}
+
+// tokenDoc is the document holding the token of a consumer.
+type tokenDoc struct {
+	Token *bson.Raw `bson:"token"`
+}
+
+func (ts *collectionTokenStore) Load(name string) (*bson.Raw, error) {
+	var doc tokenDoc
+	err := ts.c.Find(bson.D{{Key: "_id", Value: name}}).One(&doc)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+func (ts *collectionTokenStore) Save(name string, token *bson.Raw) error {
+	set := bson.D{{Key: "token", Value: token}, {Key: "time", Value: time.Now()}}
+	_, err := ts.c.Upsert(bson.D{{Key: "_id", Value: name}}, bson.D{{Key: "$set", Value: set}})
+	return err
+}
+
+// ConsumerOptions holds the options of a Consumer.
+type ConsumerOptions struct {
+	// Pipeline and ChangeStreamOptions configure the change stream. The
+	// ResumeAfter, StartAfter and StartAtOperationTime options only apply
+	// when the store holds no token for the consumer.
+	Pipeline            interface{}
+	ChangeStreamOptions ChangeStreamOptions
+
+	// Store persists the resume token of the consumer. Defaults to a
+	// collection store on the collection named after the watched one
+	// with a ".tokens" suffix, in the same database.
+	Store TokenStore
+
+	// CheckpointEvery and CheckpointInterval define how often the resume
+	// token is saved: once CheckpointEvery changes were handled, or
+	// CheckpointInterval elapsed, since the previous checkpoint. Default
+	// to DefaultCheckpointEvery and DefaultCheckpointInterval.
+	CheckpointEvery    int
+	CheckpointInterval time.Duration
+
+	// RetryDelay is the time waited before restarting the change stream
+	// after an error. Defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+
+	// OnHistoryLost is called when the resume point of the consumer is no
+	// longer in the oplog, so changes were lost. If it returns nil, the
+	// consumer restarts from the current time, typically after the
+	// function resynchronized whatever the handler maintains. Otherwise,
+	// or if OnHistoryLost is nil, Run returns the error.
+	OnHistoryLost func(err error) error
+}
+
+// Consumer delivers the changes of a collection to a handler, persisting
+// the resume token of the last change handled in a TokenStore so a later
+// run continues where the previous one stopped. Changes are delivered at
+// least once: those handled since the last checkpoint are delivered again
+// after a crash. Change streams failing with errors the driver doesn't
+// resume from are restarted after a delay.
+type Consumer struct {
+	coll    *Collection
+	name    string
+	handler ChangeHandler
+	opts    ConsumerOptions
+
+	m       sync.Mutex
+	stream  *ChangeStream
+	stopped bool
+	quit    chan struct{}
+
+	// The state of the checkpoints, only used by Run.
+	token      *bson.Raw
+	pending    int
+	checkpoint time.Time
+}
+
+// NewConsumer returns a consumer named name delivering the changes of c to
+// handler. The name identifies the consumer in the token store, so it must
+// be unique among the consumers sharing it. Opts may be nil.
+func NewConsumer(c *Collection, name string, handler ChangeHandler, opts *ConsumerOptions) *Consumer {
+	consumer := &Consumer{coll: c, name: name, handler: handler, quit: make(chan struct{})}
+	if opts != nil {
+		consumer.opts = *opts
+	}
+	if consumer.opts.Store == nil {
+		consumer.opts.Store = NewCollectionTokenStore(c.Database().C(c.Name() + ".tokens"))
+	}
+	if consumer.opts.CheckpointEvery <= 0 {
+		consumer.opts.CheckpointEvery = DefaultCheckpointEvery
+	}
+	if consumer.opts.CheckpointInterval <= 0 {
+		consumer.opts.CheckpointInterval = DefaultCheckpointInterval
+	}
+	if consumer.opts.RetryDelay <= 0 {
+		consumer.opts.RetryDelay = DefaultRetryDelay
+	}
+	if consumer.opts.ChangeStreamOptions.MaxAwaitTimeMS <= 0 {
+		// Wake up regularly so checkpoints are taken while idle.
+		consumer.opts.ChangeStreamOptions.MaxAwaitTimeMS = consumer.opts.CheckpointInterval
+	}
+	return consumer
+}
+
+// Run delivers changes to the handler until Stop is called, the handler
+// returns an error, the change stream is invalidated, or its resume point
+// is lost and OnHistoryLost doesn't recover from it. The resume token of
+// the last change handled is saved before returning. Run returns nil once
+// stopped, and must not be called concurrently.
+func (c *Consumer) Run() error {
+	token, err := c.opts.Store.Load(c.name)
+	if err != nil {
+		return err
+	}
+	c.token, c.pending, c.checkpoint = token, 0, time.Now()
+	fromNow := false
+	for {
+		cs, err := c.watch(fromNow)
+		if cs != nil {
+			if fromNow {
+				// Don't lose the new starting point to a crash.
+				c.token = cs.ResumeToken()
+				c.pending++
+				c.logSaveToken()
+				fromNow = false
+			}
+			var handlerErr bool
+			handlerErr, err = c.consume(cs)
+			_ = cs.Close()
+			if handlerErr {
+				c.logSaveToken()
+				return err
+			}
+		}
+		if c.isStopped() {
+			return c.saveToken()
+		}
+		switch {
+		case err == nil:
+			if serr := c.saveToken(); serr != nil {
+				return serr
+			}
+			return ErrChangeStreamInvalidated
+		case isCommandCode(err, changeStreamHistoryLost):
+			if c.opts.OnHistoryLost == nil {
+				return err
+			}
+			if err := c.opts.OnHistoryLost(err); err != nil {
+				return err
+			}
+			c.token = nil
+			fromNow = true
+			continue
+		case c.coll.opContext(nil).Err() != nil:
+			c.logSaveToken()
+			return err
+		}
+		logf("Consumer %s restarting its change stream in %s: %v", c.name, c.opts.RetryDelay, err)
+		select {
+		case <-c.quit:
+			return c.saveToken()
+		case <-time.After(c.opts.RetryDelay):
+		}
+	}
+}
+
+// watch starts the change stream of the consumer, resuming after the last
+// change handled, or from the current time if fromNow is set. It returns
+// no stream and no error once the consumer is stopped.
+func (c *Consumer) watch(fromNow bool) (*ChangeStream, error) {
+	opts := c.opts.ChangeStreamOptions
+	if c.token != nil || fromNow {
+		opts.ResumeAfter, opts.StartAfter, opts.StartAtOperationTime = c.token, nil, nil
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.stopped {
+		return nil, nil
+	}
+	cs, err := c.coll.Watch(c.opts.Pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.stream = cs
+	return cs, nil
+}
+
+// consume delivers the changes of cs to the handler until cs is closed or
+// fails, reporting whether it was the handler failing.
+func (c *Consumer) consume(cs *ChangeStream) (bool, error) {
+	for {
+		var raw driverbson.Raw
+		if !cs.Next(&raw) {
+			if !cs.Timeout() {
+				return false, cs.Err()
+			}
+			// The token moves past the oplog entries the stream skipped.
+			c.advance(cs.ResumeToken())
+			continue
+		}
+		event := &ChangeEvent{raw: raw}
+		if err := bson.Unmarshal(raw, event); err != nil {
+			return false, err
+		}
+		if err := c.handler(event); err != nil {
+			return true, err
+		}
+		c.advance(cs.ResumeToken())
+	}
+}
+
+// advance records token as the one of the last change handled, saving it
+// once a checkpoint is due.
+func (c *Consumer) advance(token *bson.Raw) {
+	if token != nil && (c.token == nil || !bytes.Equal(token.Value, c.token.Value)) {
+		c.token = token
+		c.pending++
+	}
+	if c.pending < c.opts.CheckpointEvery && time.Since(c.checkpoint) < c.opts.CheckpointInterval {
+		return
+	}
+	// Failures are retried with the next checkpoint.
+	c.logSaveToken()
+}
+
+// saveToken saves the token of the last change handled, if not saved yet.
+func (c *Consumer) saveToken() error {
+	c.checkpoint = time.Now()
+	if c.pending == 0 || c.token == nil {
+		return nil
+	}
+	if err := c.opts.Store.Save(c.name, c.token); err != nil {
+		return err
+	}
+	debugf("Consumer %s saved its resume token after %d changes", c.name, c.pending)
+	c.pending = 0
+	return nil
+}
+
+// logSaveToken works like saveToken, logging failures.
+func (c *Consumer) logSaveToken() {
+	if err := c.saveToken(); err != nil {
+		logf("Consumer %s failed to save its resume token: %v", c.name, err)
+	}
+}
+
+// Stop makes Run return once the change being handled, if any, is done.
+func (c *Consumer) Stop() {
+	c.m.Lock()
+	if c.stopped {
+		c.m.Unlock()
+		return
+	}
+	c.stopped = true
+	close(c.quit)
+	cs := c.stream
+	c.m.Unlock()
+	if cs != nil {
+		cs.Close()
+	}
+}
+
+func (c *Consumer) isStopped() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.stopped
+}
@@ -0,0 +1,122 @@
+package mgo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+type memTokenStore struct {
+	tokens map[string]*bson.Raw
+	saves  int
+	err    error
+}
+
+func (ts *memTokenStore) Load(name string) (*bson.Raw, error) {
+	return ts.tokens[name], nil
+}
+
+func (ts *memTokenStore) Save(name string, token *bson.Raw) error {
+	if ts.err != nil {
+		return ts.err
+	}
+	ts.tokens[name] = token
+	ts.saves++
+	return nil
+}
+
+func testToken(data string) *bson.Raw {
+	doc, err := bson.Marshal(bson.D{{Key: "_data", Value: data}})
+	if err != nil {
+		panic(err)
+	}
+	return &bson.Raw{Type: bsontype.EmbeddedDocument, Value: doc}
+}
+
+func TestConsumer_Checkpoints(t *testing.T) {
+	Convey("resume tokens are saved every N changes or T duration", t, func() {
+		coll := unconnectedSession("mydb").DB("mydb").C("orders")
+
+		consumer := NewConsumer(coll, "cache", nil, nil)
+		So(consumer.opts.Store.(*collectionTokenStore).c.FullName(), ShouldEqual, "mydb.orders.tokens")
+		So(consumer.opts.CheckpointEvery, ShouldEqual, DefaultCheckpointEvery)
+		So(consumer.opts.ChangeStreamOptions.MaxAwaitTimeMS, ShouldEqual, DefaultCheckpointInterval)
+
+		store := &memTokenStore{tokens: map[string]*bson.Raw{}}
+		consumer = NewConsumer(coll, "cache", nil, &ConsumerOptions{Store: store, CheckpointEvery: 2, CheckpointInterval: time.Hour})
+		consumer.checkpoint = time.Now()
+		consumer.advance(testToken("01"))
+		So(store.saves, ShouldEqual, 0)
+		consumer.advance(testToken("01"))
+		So(store.saves, ShouldEqual, 0)
+		consumer.advance(testToken("02"))
+		So(store.saves, ShouldEqual, 1)
+		So(store.tokens["cache"], ShouldResemble, testToken("02"))
+
+		consumer.advance(testToken("03"))
+		consumer.checkpoint = time.Now().Add(-2 * time.Hour)
+		consumer.advance(nil)
+		So(store.saves, ShouldEqual, 2)
+		So(store.tokens["cache"], ShouldResemble, testToken("03"))
+
+		store.err = errors.New("unavailable")
+		consumer.advance(testToken("04"))
+		consumer.advance(testToken("05"))
+		So(consumer.pending, ShouldEqual, 2)
+		store.err = nil
+		So(consumer.saveToken(), ShouldBeNil)
+		So(consumer.pending, ShouldEqual, 0)
+		So(store.tokens["cache"], ShouldResemble, testToken("05"))
+	})
+
+	Convey("stopped consumers return without watching", t, func() {
+		coll := unconnectedSession("mydb").DB("mydb").C("orders")
+		store := &memTokenStore{tokens: map[string]*bson.Raw{}}
+		consumer := NewConsumer(coll, "cache", nil, &ConsumerOptions{Store: store})
+		consumer.Stop()
+		consumer.Stop()
+		So(consumer.Run(), ShouldBeNil)
+	})
+}
+
+func TestChangeEvent_Unmarshal(t *testing.T) {
+	Convey("change events expose the common fields and the whole document", t, func() {
+		data, err := bson.Marshal(bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "01"}}},
+			{Key: "operationType", Value: "insert"},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: 1}}},
+			{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: 2}}},
+		})
+		So(err, ShouldBeNil)
+		event := &ChangeEvent{raw: data}
+		So(bson.Unmarshal(data, event), ShouldBeNil)
+		So(event.ResumeToken, ShouldResemble, testToken("01"))
+		So(event.OperationType, ShouldEqual, "insert")
+		So(event.DocumentKey, ShouldResemble, bson.D{{Key: "_id", Value: int32(1)}})
+
+		var change struct {
+			FullDocument struct{ N int } `bson:"fullDocument"`
+		}
+		So(event.Unmarshal(&change), ShouldBeNil)
+		So(change.FullDocument.N, ShouldEqual, 2)
+	})
+}
+
+func TestCollectionTokenStore(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		store := NewCollectionTokenStore(ctx.mongo.DB("mydb").C("orders.tokens"))
+		token, err := store.Load("5f0000000000000000000001")
+		So(err, ShouldBeNil)
+		So(token, ShouldBeNil)
+
+		So(store.Save("5f0000000000000000000001", testToken("01")), ShouldBeNil)
+		So(store.Save("5f0000000000000000000001", testToken("02")), ShouldBeNil)
+		token, err = store.Load("5f0000000000000000000001")
+		So(err, ShouldBeNil)
+		So(token, ShouldResemble, testToken("02"))
+	})
+}
@@ -0,0 +1,174 @@
+package mgo
+
+import (
+	"github.com/Masterminds/semver"
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// copyBatchSize is the number of documents written per round trip when
+// collections are copied client-side.
+const copyBatchSize = 1000
+
+var (
+	mergeConstraint, _ = semver.NewConstraint(">=4.2")
+	outConstraint, _   = semver.NewConstraint(">=2.6")
+)
+
+// CopyCollection copies the documents of the src collection matching filter
+// into the dst collection, replacing the documents of dst with the same _id.
+// A nil filter copies all the documents. Src and dst are names of
+// collections in d unless qualified by the name of their database, as in
+// "otherdb.coll", to copy across databases.
+//
+// If dst doesn't exist, it's created with the options and the indexes of
+// src. Views are copied into regular collections.
+//
+// The documents are copied by the server with an aggregation ending in a
+// $merge stage on MongoDB 4.2 or later, or in an $out stage on earlier
+// versions when dst is created in the same database. Otherwise, they are
+// read and written back in batches.
+func (d *Database) CopyCollection(src, dst string, filter interface{}) error {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	srcC, dstC := d.qualifiedC(src), d.qualifiedC(dst)
+	spec, err := srcC.spec()
+	if err != nil {
+		return err
+	}
+	target, err := dstC.spec()
+	created := err == ErrNotFound
+	switch {
+	case created:
+		target = &CollectionSpec{Type: "collection"}
+		if spec.Type != "view" {
+			target.Type, target.Info = spec.Type, spec.Info
+		}
+		if err := dstC.Create(&target.Info); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	// The aggregation stages can't write to capped or time-series
+	// collections.
+	serverSide := target.Type == "collection" && !target.Info.Capped
+	version := d.Version()
+	switch {
+	case serverSide && version != nil && mergeConstraint.Check(version):
+		merge := bson.D{
+			{Key: "into", Value: bson.D{{Key: "db", Value: dstC.db.Name()}, {Key: "coll", Value: dstC.Name()}}},
+			{Key: "on", Value: "_id"},
+			{Key: "whenMatched", Value: "replace"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}
+		err = srcC.Pipe(bson.A{bson.D{{Key: "$match", Value: filter}}, bson.D{{Key: "$merge", Value: merge}}}).AllowDiskUse().Iter().Close()
+	case serverSide && version != nil && outConstraint.Check(version) && created && dstC.db.Name() == srcC.db.Name():
+		// $out replaces the contents of dst, empty as it was just created,
+		// preserving its options and indexes.
+		err = srcC.Pipe(bson.A{bson.D{{Key: "$match", Value: filter}}, bson.D{{Key: "$out", Value: dstC.Name()}}}).AllowDiskUse().Iter().Close()
+	default:
+		err = copyDocuments(srcC, dstC, filter, created)
+	}
+	if err != nil || !created || spec.Type == "view" {
+		return err
+	}
+
+	return copyIndexes(srcC, dstC)
+}
+
+// copyIndexes creates the indexes of src on dst. The index specifications
+// are copied as listed by the server, so that options Index doesn't
+// represent, such as hidden indexes or the details of collations, are
+// preserved.
+func copyIndexes(src, dst *Collection) error {
+	specs, err := src.indexSpecs()
+	if err != nil || len(specs) == 0 {
+		return err
+	}
+	return dst.db.Run(bson.D{{Key: "createIndexes", Value: dst.Name()}, {Key: "indexes", Value: specs}}, nil)
+}
+
+// indexSpecs returns the specifications of the indexes of c other than the
+// _id one, as listed by the server but for their namespace, which servers
+// older than 4.4 report and reject when creating indexes elsewhere.
+func (c *Collection) indexSpecs() (specs bson.A, err error) {
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	cursor, err := c.collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var spec, filtered bson.D
+		if err := cursor.Decode(&spec); err != nil {
+			return nil, err
+		}
+		id := false
+		for _, e := range spec {
+			switch e.Key {
+			case "ns":
+				continue
+			case "name":
+				id = e.Value == "_id_"
+			}
+			filtered = append(filtered, e)
+		}
+		if !id {
+			specs = append(specs, filtered)
+		}
+	}
+	return specs, cursor.Err()
+}
+
+// copyDocuments copies the documents of src matching filter into dst in
+// batches. Documents are inserted if dst was empty, and replace those with
+// the same _id otherwise.
+func copyDocuments(src, dst *Collection, filter interface{}, empty bool) error {
+	iter := src.Find(filter).Batch(copyBatchSize).Iter()
+	models := make([]mongo.WriteModel, 0, copyBatchSize)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		ctx, done, err := dst.begin(nil)
+		if err != nil {
+			return err
+		}
+		defer done()
+		_, err = dst.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err == mongo.ErrUnacknowledgedWrite {
+			err = nil
+		}
+		models = models[:0]
+		return err
+	}
+	var raw driverbson.Raw
+	for iter.Next(&raw) {
+		if empty {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(raw))
+		} else {
+			id := bson.D{{Key: "_id", Value: raw.Lookup("_id")}}
+			models = append(models, mongo.NewReplaceOneModel().SetFilter(id).SetReplacement(raw).SetUpsert(true))
+		}
+		if len(models) == copyBatchSize {
+			if err := flush(); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+		raw = nil
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,111 @@
+package mgo
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestDatabase_QualifiedC(t *testing.T) {
+	Convey("collection names may be qualified by a database", t, func() {
+		session := unconnectedSession("mydb")
+		session.SetSafe(&Safe{WMode: "majority"})
+		db := session.DB("mydb")
+		So(db.qualifiedC("orders").FullName(), ShouldEqual, "mydb.orders")
+		So(db.qualifiedC("other.orders").FullName(), ShouldEqual, "other.orders")
+		So(db.qualifiedC("other.orders.tokens").FullName(), ShouldEqual, "other.orders.tokens")
+		So(db.sibling("mydb"), ShouldEqual, db)
+		So(db.sibling("admin").database.WriteConcern(), ShouldResemble, writeconcern.New(writeconcern.WMajority()))
+	})
+}
+
+func TestCollection_Rename(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo
+		db := session.DB("mydb")
+		So(db.C("a").Insert(M{"_id": 1}), ShouldBeNil)
+		So(db.C("b").Insert(M{"_id": 2}), ShouldBeNil)
+
+		So(db.C("a").Rename("b", false), ShouldNotBeNil)
+		So(db.C("a").Rename("b", true), ShouldBeNil)
+		var doc M
+		So(db.C("b").FindId(1).One(&doc), ShouldBeNil)
+		So(db.C("a").FindId(1).One(&doc), ShouldEqual, ErrNotFound)
+
+		So(db.C("b").Rename("otherdb.c", false), ShouldBeNil)
+		So(session.DB("otherdb").C("c").FindId(1).One(&doc), ShouldBeNil)
+	})
+}
+
+func TestDatabase_CopyCollection(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo
+		db := session.DB("mydb")
+		src := db.C("src")
+		So(src.Create(&CollectionInfo{Validator: bson.M{"n": bson.M{"$gte": 0}}}), ShouldBeNil)
+		So(src.EnsureIndex(Index{Key: []string{"n"}, Unique: true}), ShouldBeNil)
+		So(src.Insert(M{"_id": 1, "n": 1}, M{"_id": 2, "n": 2}, M{"_id": 3, "n": 3}), ShouldBeNil)
+
+		So(db.CopyCollection("src", "dst", bson.M{"n": bson.M{"$gte": 2}}), ShouldBeNil)
+		dst := db.C("dst")
+		count, err := dst.Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		info, err := dst.Info()
+		So(err, ShouldBeNil)
+		So(info.Validator, ShouldNotBeNil)
+		indexes, err := dst.Indexes()
+		So(err, ShouldBeNil)
+		So(indexes, ShouldHaveLength, 2)
+		So(indexes[1].Unique, ShouldBeTrue)
+
+		// Existing collections are merged into.
+		So(src.UpdateId(2, M{"$set": M{"n": 20}}), ShouldBeNil)
+		So(db.CopyCollection("src", "dst", nil), ShouldBeNil)
+		var doc struct{ N int }
+		So(dst.FindId(2).One(&doc), ShouldBeNil)
+		So(doc.N, ShouldEqual, 20)
+		count, err = dst.Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+
+		// Across databases, and client-side into capped collections.
+		So(db.CopyCollection("src", "otherdb.dst", nil), ShouldBeNil)
+		count, err = session.DB("otherdb").C("dst").Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+		So(db.C("capped").Create(&CollectionInfo{Capped: true, MaxBytes: 1 << 20}), ShouldBeNil)
+		So(db.CopyCollection("src", "capped", nil), ShouldBeNil)
+		count, err = db.C("capped").Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+
+		So(db.CopyCollection("missing", "dst", nil), ShouldEqual, ErrNotFound)
+	})
+}
+
+func TestDatabase_CopyCollectionIndexSpecs(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("mydb")
+		src := db.C("src")
+		So(src.EnsureIndex(Index{Key: []string{"name"}, Collation: &Collation{Locale: "fr", Strength: 2}}), ShouldBeNil)
+		So(src.Insert(M{"_id": 1, "name": "a"}), ShouldBeNil)
+
+		So(db.CopyCollection("src", "dst", nil), ShouldBeNil)
+		specs, err := db.C("dst").indexSpecs()
+		So(err, ShouldBeNil)
+		So(specs, ShouldHaveLength, 1)
+		var index struct {
+			Name      string
+			Collation collationSpec
+		}
+		data, err := bson.Marshal(specs[0])
+		So(err, ShouldBeNil)
+		So(bson.Unmarshal(data, &index), ShouldBeNil)
+		So(index.Name, ShouldEqual, "name_1")
+		So(index.Collation.Locale, ShouldEqual, "fr")
+		So(index.Collation.Strength, ShouldEqual, 2)
+	})
+}
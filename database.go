@@ -4,7 +4,9 @@ import (
 	"context"
 	"github.com/Masterminds/semver"
 	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,41 +15,154 @@ import (
 // Database session-driver db
 type Database struct {
 	database *mongo.Database
-	version  *semver.Version
+	session  *Session
+	settings opSettings
+	limiter  *opLimiter
+	registry *bsoncodec.Registry
+	ctx      context.Context
+	err      error
+
+	driverSession mongo.Session
 }
 
 // C returns coll.
 func (d *Database) C(collection string) *Collection {
-	return &Collection{db: d, collection: d.database.Collection(collection)}
+	return &Collection{db: d, collection: d.database.Collection(collection), ctx: d.ctx, err: d.err}
+}
+
+// With returns a copy of d that uses session s, taking on its client,
+// mode, safety, context and transaction.
+func (d *Database) With(s *Session) *Database {
+	return s.DB(d.Name())
+}
+
+// WithContext returns a shallow copy of the database bound to ctx. The
+// collections obtained from it and their operations run with ctx.
+// See Session.WithContext.
+func (d *Database) WithContext(ctx context.Context) *Database {
+	if ctx == nil {
+		panic("nil context")
+	}
+	dcopy := *d
+	dcopy.ctx = ctx
+	return &dcopy
+}
+
+// opContext returns ctx, or the context bound to the database if ctx is nil,
+// carrying the driver session of the session the database was obtained from.
+func (d *Database) opContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = contextOrBackground(d.ctx)
+	}
+	return withDriverSession(ctx, d.driverSession)
+}
+
+// begin starts an operation with opContext(ctx), applying the timeouts and
+// pool limit the session had when the database was obtained. The returned
+// function must be called once the operation completes.
+func (d *Database) begin(ctx context.Context) (context.Context, func(), error) {
+	return d.settings.begin(d.opContext(ctx), d.limiter)
+}
+
+// sibling returns the database named name, obtained like d, with the
+// same concerns, settings, context and transaction.
+func (d *Database) sibling(name string) *Database {
+	if name == d.Name() {
+		return d
+	}
+	opts := options.Database().
+		SetReadConcern(d.database.ReadConcern()).
+		SetWriteConcern(d.database.WriteConcern()).
+		SetReadPreference(d.database.ReadPreference())
+	dcopy := *d
+	dcopy.database = d.database.Client().Database(name, opts)
+	return &dcopy
+}
+
+// qualifiedC returns the collection named name, which is in d unless
+// qualified by the name of its database, as in "otherdb.coll".
+func (d *Database) qualifiedC(name string) *Collection {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return d.sibling(name[:i]).C(name[i+1:])
+	}
+	return d.C(name)
 }
 
 func (d *Database) GridFS(prefix string) *GridFS {
 	opts := options.GridFSBucket().SetName(prefix)
 	bucket, _ := gridfs.NewBucket(d.database, opts)
-	return &GridFS{bucket: bucket, fileColl: &Collection{collection: bucket.GetFilesCollection()}}
+	ctx := d.opContext(nil)
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = bucket.SetReadDeadline(deadline)
+		_ = bucket.SetWriteDeadline(deadline)
+	}
+	return &GridFS{bucket: bucket, ctx: ctx, fileColl: &Collection{db: d, collection: bucket.GetFilesCollection(), ctx: d.ctx, err: d.err}}
 }
 
 func (d *Database) Run(cmd interface{}, t interface{}) error {
+	return d.RunCtx(nil, cmd, t)
+}
+
+// RunCtx works like Run, using ctx instead of the context bound to the
+// database when it is not nil.
+func (d *Database) RunCtx(ctx context.Context, cmd interface{}, t interface{}) error {
 	if name, ok := cmd.(string); ok {
 		cmd = bson.D{{Key: name, Value: 1}}
 	}
-	o := d.database.RunCommand(context.Background(), cmd)
+	ctx, done, err := d.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	o := d.database.RunCommand(ctx, cmd)
 	if t == nil {
-		return nil
+		return o.Err()
 	}
 	return o.Decode(t)
 }
 
 func (d *Database) DropDatabase() error {
-	return d.database.Drop(context.Background())
+	ctx, done, err := d.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return d.database.Drop(ctx)
 }
 
+// Session returns the session the database was obtained from.
+func (d *Database) Session() *Session {
+	return d.session
+}
+
+// Name returns the name of the database.
+func (d *Database) Name() string {
+	return d.database.Name()
+}
+
+// Close closes the session the database was obtained from.
+// See Session.Close.
 func (d *Database) Close() {
+	d.session.Close()
 }
 
 func (d *Database) CollectionNames() ([]string, error) {
-	return d.database.ListCollectionNames(nil, bson.M{})
+	ctx, done, err := d.begin(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return d.database.ListCollectionNames(ctx, bson.M{})
 }
+
+// Version returns the version of the server, or nil if it could not be
+// determined.
 func (d *Database) Version() *semver.Version {
-	return d.version
+	d.session.m.RLock()
+	cluster := d.session.cluster
+	d.session.m.RUnlock()
+	if cluster == nil {
+		return nil
+	}
+	return cluster.serverVersion()
 }
@@ -0,0 +1,154 @@
+package mgo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DBRef is a reference to a document, stored in documents as
+// {$ref, $id, $db}. It's used in the original MongoDB drivers and
+// libraries, although applications are encouraged to store plain ids
+// instead when possible.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/database-references/#dbrefs
+type DBRef struct {
+	Collection string      `bson:"$ref"`
+	Id         interface{} `bson:"$id"`
+	Database   string      `bson:"$db,omitempty"`
+}
+
+// FindRef returns a query that looks for the document in the provided
+// reference. If the reference includes the DB field, the document will
+// be retrieved from the respective database.
+//
+// See also the DBRef type and the FindRef method on Session.
+func (d *Database) FindRef(ref *DBRef) *Query {
+	var c *Collection
+	if ref.Database == "" {
+		c = d.C(ref.Collection)
+	} else {
+		c = d.sibling(ref.Database).C(ref.Collection)
+	}
+	return c.findRef(ref)
+}
+
+// FindRef returns a query that looks for the document in the provided
+// reference. For a DBRef to be resolved correctly at the session level
+// it must necessarily have the optional DB field defined.
+//
+// See also the DBRef type and the FindRef method on Database.
+func (s *Session) FindRef(ref *DBRef) *Query {
+	if ref.Database == "" {
+		panic(fmt.Errorf("Can't resolve database for %#v", ref))
+	}
+	return s.DB(ref.Database).C(ref.Collection).findRef(ref)
+}
+
+// findRef returns a query for the document of ref in c. Unlike FindId,
+// the id is used as is, even when it's an ObjectId in hex form.
+func (c *Collection) findRef(ref *DBRef) *Query {
+	return c.Find(bson.D{{Key: "_id", Value: ref.Id}})
+}
+
+// FindRefs resolves refs into results, which must be a pointer to a slice.
+// Results is set to a slice holding the document of each ref at the same
+// index, or the zero value of the slice elements if the document doesn't
+// exist. The refs without the DB field are resolved in d. A single query
+// is issued per collection referred to.
+func (d *Database) FindRefs(refs []DBRef, results interface{}) error {
+	resultv := reflect.ValueOf(results)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return errors.New("FindRefs: results argument must be a slice address")
+	}
+	slicev := reflect.MakeSlice(resultv.Elem().Type(), len(refs), len(refs))
+	elemt := slicev.Type().Elem()
+
+	// Group the refs by collection, in order of appearance.
+	type target struct {
+		db, coll string
+	}
+	var targets []target
+	indexes := make(map[target]map[string][]int)
+	ids := make(map[target]bson.A)
+	for i := range refs {
+		t := target{refs[i].Database, refs[i].Collection}
+		if t.db == "" {
+			t.db = d.Name()
+		}
+		key, err := refIdKey(refs[i].Id)
+		if err != nil {
+			return err
+		}
+		if indexes[t] == nil {
+			targets = append(targets, t)
+			indexes[t] = make(map[string][]int)
+		}
+		if indexes[t][key] == nil {
+			ids[t] = append(ids[t], refs[i].Id)
+		}
+		indexes[t][key] = append(indexes[t][key], i)
+	}
+
+	for _, t := range targets {
+		c := d.sibling(t.db).C(t.coll)
+		iter := c.Find(bson.M{"_id": bson.M{"$in": ids[t]}}).Iter()
+		var raw driverbson.Raw
+		for iter.Next(&raw) {
+			id, err := raw.LookupErr("_id")
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			for _, i := range indexes[t][idKey(id)] {
+				elemp := reflect.New(elemt)
+				if err := bson.Unmarshal(raw, elemp.Interface()); err != nil {
+					iter.Close()
+					return err
+				}
+				slicev.Index(i).Set(elemp.Elem())
+			}
+			raw = nil
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+	resultv.Elem().Set(slicev)
+	return nil
+}
+
+// refIdKey returns the key of id for matching the documents found.
+func refIdKey(id interface{}) (string, error) {
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return "", err
+	}
+	return idKey(driverbson.Raw(doc).Lookup("_id")), nil
+}
+
+// idKey returns a key identifying id, which is equal for numbers of
+// different types holding the same value, as the server matches them.
+func idKey(id driverbson.RawValue) string {
+	switch id.Type {
+	case bsontype.Int32:
+		return "n" + strconv.FormatInt(int64(id.Int32()), 10)
+	case bsontype.Int64:
+		return "n" + strconv.FormatInt(id.Int64(), 10)
+	case bsontype.Double:
+		f := id.Double()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return "n" + strconv.FormatInt(int64(f), 10)
+		}
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return string(id.Type) + string(id.Value)
+}
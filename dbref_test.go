@@ -0,0 +1,103 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestDBRef_BSON(t *testing.T) {
+	Convey("references are stored as $ref, $id and $db", t, func() {
+		id := bson.ObjectIdHex("5f0000000000000000000001")
+		data, err := bson.Marshal(bson.M{"owner": DBRef{Collection: "people", Id: id, Database: "mydb"}})
+		So(err, ShouldBeNil)
+		var doc struct {
+			Owner bson.D `bson:"owner"`
+		}
+		So(bson.Unmarshal(data, &doc), ShouldBeNil)
+		So(doc.Owner, ShouldResemble, bson.D{{Key: "$ref", Value: "people"}, {Key: "$id", Value: id}, {Key: "$db", Value: "mydb"}})
+
+		var ref struct {
+			Owner DBRef `bson:"owner"`
+		}
+		So(bson.Unmarshal(data, &ref), ShouldBeNil)
+		So(ref.Owner, ShouldResemble, DBRef{Collection: "people", Id: id, Database: "mydb"})
+
+		data, err = bson.Marshal(DBRef{Collection: "people", Id: 1})
+		So(err, ShouldBeNil)
+		So(bson.Unmarshal(data, &doc.Owner), ShouldBeNil)
+		So(doc.Owner, ShouldResemble, bson.D{{Key: "$ref", Value: "people"}, {Key: "$id", Value: int32(1)}})
+	})
+}
+
+func TestIdKey(t *testing.T) {
+	Convey("ids are matched as the server does", t, func() {
+		key := func(id interface{}) string {
+			k, err := refIdKey(id)
+			So(err, ShouldBeNil)
+			return k
+		}
+		So(key(1), ShouldEqual, key(int64(1)))
+		So(key(1), ShouldEqual, key(1.0))
+		So(key(1), ShouldNotEqual, key(1.5))
+		So(key(1), ShouldNotEqual, key("1"))
+		So(key(bson.ObjectIdHex("5f0000000000000000000001")), ShouldNotEqual, key("5f0000000000000000000001"))
+		So(key(bson.D{{Key: "a", Value: 1}}), ShouldEqual, key(bson.M{"a": 1}))
+	})
+}
+
+func TestDatabase_FindRefSibling(t *testing.T) {
+	Convey("references to other databases are resolved like the database", t, func() {
+		session := unconnectedSession("mydb")
+		session.SetMode(Secondary, true)
+		session.SetSocketTimeout(time.Second)
+		ctx := context.WithValue(context.Background(), struct{}{}, "value")
+		db := session.DB("db1").WithContext(ctx)
+
+		c := db.FindRef(&DBRef{Collection: "people", Id: 1, Database: "db2"}).coll
+		So(c.db.Name(), ShouldEqual, "db2")
+		So(c.ctx, ShouldEqual, ctx)
+		So(c.db.settings, ShouldResemble, db.settings)
+		So(c.collection.Database().ReadPreference().Mode(), ShouldEqual, readpref.SecondaryMode)
+	})
+}
+
+func TestDatabase_FindRef(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo
+		db := session.DB("db1")
+		So(db.C("people").Insert(M{"_id": 1, "name": "ann"}, M{"_id": 2, "name": "bob"}), ShouldBeNil)
+		So(session.DB("db2").C("people").Insert(M{"_id": "5f0000000000000000000001", "name": "cid"}), ShouldBeNil)
+
+		var person struct{ Name string }
+		So(db.FindRef(&DBRef{Collection: "people", Id: 2}).One(&person), ShouldBeNil)
+		So(person.Name, ShouldEqual, "bob")
+		ref := &DBRef{Collection: "people", Id: "5f0000000000000000000001", Database: "db2"}
+		So(db.FindRef(ref).One(&person), ShouldBeNil)
+		So(person.Name, ShouldEqual, "cid")
+		So(session.FindRef(ref).One(&person), ShouldBeNil)
+		So(func() { session.FindRef(&DBRef{Collection: "people", Id: 1}) }, ShouldPanic)
+
+		var people []*struct{ Name string }
+		err := db.FindRefs([]DBRef{
+			{Collection: "people", Id: int64(2)},
+			{Collection: "people", Id: "5f0000000000000000000001", Database: "db2"},
+			{Collection: "people", Id: 3},
+			{Collection: "people", Id: 1},
+			{Collection: "people", Id: 2},
+		}, &people)
+		So(err, ShouldBeNil)
+		So(people, ShouldHaveLength, 5)
+		So(people[0].Name, ShouldEqual, "bob")
+		So(people[1].Name, ShouldEqual, "cid")
+		So(people[2], ShouldBeNil)
+		So(people[3].Name, ShouldEqual, "ann")
+		So(people[4].Name, ShouldEqual, "bob")
+
+		So(db.FindRefs(nil, people), ShouldNotBeNil)
+	})
+}
@@ -24,7 +24,9 @@ type GridFile struct {
 
 func (gf *GridFile) Read(p []byte) (n int, err error) {
 	gf.assertMode(gfsReading)
-
+	if err = gf.gfs.ctx.Err(); err != nil {
+		return 0, err
+	}
 	return gf.downloadStream.Read(p)
 }
 func (gf *GridFile) assertMode(mode gfsFileMode) {
@@ -47,16 +49,22 @@ func newGridFile(gfs *GridFS, fileName string, mode gfsFileMode) (file *GridFile
 
 	switch mode {
 	case gfsReading:
+		if err := gfs.ctx.Err(); err != nil {
+			return nil, err
+		}
 		ds, err := gfs.bucket.OpenDownloadStreamByName(fileName)
 		if err != nil {
 			return nil, err
 		}
+		if deadline, ok := gfs.ctx.Deadline(); ok {
+			_ = ds.SetReadDeadline(deadline)
+		}
 
 		gfsFile.downloadStream = ds
 		gfsFile.fileId = ds.GetFile().ID
 		gfsFile.fileSize = ds.GetFile().Length
 	case gfsWriting:
-		us, err := gfs.bucket.OpenUploadStream(fileName)
+		us, err := gfs.CreateStream(fileName)
 		if err != nil {
 			return nil, err
 		}
@@ -71,6 +79,9 @@ func newGridFile(gfs *GridFS, fileName string, mode gfsFileMode) (file *GridFile
 
 func (gf *GridFile) Write(content []byte) (int, error) {
 	gf.assertMode(gfsWriting)
+	if err := gf.gfs.ctx.Err(); err != nil {
+		return -1, err
+	}
 	n, err := gf.uploadStream.Write(content)
 	if err != nil {
 		return -1, err
@@ -79,8 +90,15 @@ func (gf *GridFile) Write(content []byte) (int, error) {
 	return n, nil
 }
 
+// Close flushes any pending data and closes the file. If the context of
+// the GridFS is done, an upload in progress is aborted instead and the
+// context error is returned.
 func (gf *GridFile) Close() (err error) {
 	if gf.uploadStream != nil {
+		if err = gf.gfs.ctx.Err(); err != nil {
+			_ = gf.uploadStream.Abort()
+			return err
+		}
 		err = gf.uploadStream.Close()
 	}
 	if gf.downloadStream != nil {
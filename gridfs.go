@@ -1,6 +1,7 @@
 package mgo
 
 import (
+	"context"
 	"github.com/yaziming/mgo/bson"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
 )
@@ -9,10 +10,34 @@ type GridFS struct {
 	bucket   *gridfs.Bucket
 	fileColl *Collection
 	fileName string
+	ctx      context.Context
+}
+
+// WithContext returns a shallow copy of the GridFS bound to ctx. The files
+// opened from it stop transferring data once ctx is done.
+// See Session.WithContext.
+func (g *GridFS) WithContext(ctx context.Context) *GridFS {
+	if ctx == nil {
+		panic("nil context")
+	}
+	gcopy := *g
+	gcopy.ctx = ctx
+	gcopy.fileColl = g.fileColl.WithContext(ctx)
+	return &gcopy
 }
 
 func (g *GridFS) CreateStream(name string) (*gridfs.UploadStream, error) {
-	return g.bucket.OpenUploadStream(name)
+	if err := g.ctx.Err(); err != nil {
+		return nil, err
+	}
+	us, err := g.bucket.OpenUploadStream(name)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := g.ctx.Deadline(); ok {
+		_ = us.SetWriteDeadline(deadline)
+	}
+	return us, nil
 }
 func (g *GridFS) Create(name string) (file *GridFile, err error) {
 	us, err := g.CreateStream(name)
@@ -44,6 +69,9 @@ func (g *GridFS) Remove(name string) (err error) {
 
 }
 func (g *GridFS) RemoveId(id interface{}) (err error) {
+	if err := g.ctx.Err(); err != nil {
+		return err
+	}
 	return g.bucket.Delete(queryID(id))
 }
 func (g *GridFS) Find(query interface{}) *Query {
@@ -51,7 +79,17 @@ func (g *GridFS) Find(query interface{}) *Query {
 }
 
 func (g *GridFS) OpenStreamId(id bson.ObjectId) (*gridfs.DownloadStream, error) {
-	return g.bucket.OpenDownloadStream(id)
+	if err := g.ctx.Err(); err != nil {
+		return nil, err
+	}
+	ds, err := g.bucket.OpenDownloadStream(id)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := g.ctx.Deadline(); ok {
+		_ = ds.SetReadDeadline(deadline)
+	}
+	return ds, nil
 }
 
 func (g *GridFS) Close() {
@@ -66,7 +104,7 @@ func (g *GridFS) Open(name string) (file *GridFile, err error) {
 	return
 }
 func (g *GridFS) OpenId(id bson.ObjectId) (file *GridFile, err error) {
-	stream, err := g.bucket.OpenDownloadStream(id)
+	stream, err := g.OpenStreamId(id)
 	if err != nil {
 		if err == gridfs.ErrFileNotFound {
 			err = ErrNotFound
@@ -1,7 +1,6 @@
 package mgo
 
 import (
-	"context"
 	"errors"
 	"github.com/yaziming/mgo/bson"
 	"sort"
@@ -185,7 +184,11 @@ type indexSpec struct {
 }
 
 func (c *Collection) Indexes() (indexes []Index, err error) {
-	ctx := context.Background()
+	ctx, done, err := c.begin(nil)
+	if err != nil {
+		return
+	}
+	defer done()
 	cursor, err := c.collection.Indexes().List(ctx)
 	if err != nil {
 		return
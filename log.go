@@ -0,0 +1,200 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// logLogger is the interface of the loggers accepted by SetLogger. It is
+// satisfied by *log.Logger.
+type logLogger interface {
+	Output(calldepth int, s string) error
+}
+
+// maxLoggedCommand is the length beyond which logged commands are truncated.
+const maxLoggedCommand = 1024
+
+var (
+	globalMutex   sync.RWMutex
+	globalLogger  logLogger
+	globalDebug   bool
+	redactedKeys  = map[string]bool{"password": true, "pwd": true, "payload": true}
+	startedDebugs = make(map[string]string)
+)
+
+// SetLogger specifies the *log.Logger object where log messages should be
+// sent to.
+func SetLogger(logger logLogger) {
+	globalMutex.Lock()
+	globalLogger = logger
+	globalMutex.Unlock()
+}
+
+// SetDebug enables the delivery of debug messages to the logger, including
+// a line for every command started, succeeded or failed in the clients
+// dialed by this package. Only meaningful if a logger is also set.
+func SetDebug(debug bool) {
+	globalMutex.Lock()
+	globalDebug = debug
+	if !debug {
+		startedDebugs = make(map[string]string)
+	}
+	globalMutex.Unlock()
+}
+
+// SetRedactedKeys replaces the keys whose values are replaced by
+// "<redacted>" in logged commands, at any depth. The keys are matched
+// case-insensitively. By default "password", "pwd" and "payload" are
+// redacted. Security sensitive commands such as authentication are never
+// logged with their arguments.
+func SetRedactedKeys(keys ...string) {
+	redacted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		redacted[strings.ToLower(key)] = true
+	}
+	globalMutex.Lock()
+	redactedKeys = redacted
+	globalMutex.Unlock()
+}
+
+func logf(format string, params ...interface{}) {
+	globalMutex.RLock()
+	logger := globalLogger
+	globalMutex.RUnlock()
+	if logger != nil {
+		_ = logger.Output(2, fmt.Sprintf(format, params...))
+	}
+}
+
+func debugf(format string, params ...interface{}) {
+	globalMutex.RLock()
+	logger, debug := globalLogger, globalDebug
+	globalMutex.RUnlock()
+	if debug && logger != nil {
+		_ = logger.Output(2, fmt.Sprintf(format, params...))
+	}
+}
+
+// debugging reports whether debug messages are delivered.
+func debugging() bool {
+	globalMutex.RLock()
+	defer globalMutex.RUnlock()
+	return globalDebug && globalLogger != nil
+}
+
+// monitorLog returns a copy of opts logging the commands of the client when
+// debugging. The monitors already set in opts keep receiving all events.
+func monitorLog(opts *options.ClientOptions) *options.ClientOptions {
+	var commandMonitor event.CommandMonitor
+	if opts.Monitor != nil {
+		commandMonitor = *opts.Monitor
+	}
+	started := commandMonitor.Started
+	commandMonitor.Started = func(ctx context.Context, e *event.CommandStartedEvent) {
+		if debugging() {
+			logStarted(e)
+		}
+		if started != nil {
+			started(ctx, e)
+		}
+	}
+	succeeded := commandMonitor.Succeeded
+	commandMonitor.Succeeded = func(ctx context.Context, e *event.CommandSucceededEvent) {
+		if debugging() {
+			database := finishedDatabase(e.CommandFinishedEvent)
+			debugf("Command %s on %s succeeded in %v (request %d on %s): reply of %d bytes",
+				e.CommandName, database, time.Duration(e.DurationNanos), e.RequestID, e.ConnectionID, len(e.Reply))
+		}
+		if succeeded != nil {
+			succeeded(ctx, e)
+		}
+	}
+	failed := commandMonitor.Failed
+	commandMonitor.Failed = func(ctx context.Context, e *event.CommandFailedEvent) {
+		if debugging() {
+			database := finishedDatabase(e.CommandFinishedEvent)
+			debugf("Command %s on %s failed in %v (request %d on %s): %s",
+				e.CommandName, database, time.Duration(e.DurationNanos), e.RequestID, e.ConnectionID, e.Failure)
+		}
+		if failed != nil {
+			failed(ctx, e)
+		}
+	}
+	return options.MergeClientOptions(opts).SetMonitor(&commandMonitor)
+}
+
+func logStarted(e *event.CommandStartedEvent) {
+	command := "<redacted>"
+	if len(e.Command) > 0 {
+		globalMutex.RLock()
+		keys := redactedKeys
+		globalMutex.RUnlock()
+		if data, err := bson.MarshalExtJSON(redact(e.Command, keys), false, false); err == nil {
+			command = string(data)
+		} else {
+			command = fmt.Sprintf("<%v>", err)
+		}
+		if len(command) > maxLoggedCommand {
+			command = command[:maxLoggedCommand] + "..."
+		}
+	}
+	globalMutex.Lock()
+	startedDebugs[startedKey(e.RequestID, e.ConnectionID)] = e.DatabaseName
+	globalMutex.Unlock()
+	debugf("Command %s on %s started (request %d on %s): %s", e.CommandName, e.DatabaseName, e.RequestID, e.ConnectionID, command)
+}
+
+// finishedDatabase returns the database of the started command e finishes.
+func finishedDatabase(e event.CommandFinishedEvent) string {
+	key := startedKey(e.RequestID, e.ConnectionID)
+	globalMutex.Lock()
+	database, ok := startedDebugs[key]
+	delete(startedDebugs, key)
+	globalMutex.Unlock()
+	if !ok {
+		return "?"
+	}
+	return database
+}
+
+func startedKey(requestID int64, connectionID string) string {
+	return fmt.Sprintf("%s/%d", connectionID, requestID)
+}
+
+// redact returns doc with the values under keys replaced, at any depth.
+func redact(doc bson.Raw, keys map[string]bool) bson.D {
+	elements, _ := doc.Elements()
+	redacted := make(bson.D, 0, len(elements))
+	for _, element := range elements {
+		key := element.Key()
+		if keys[strings.ToLower(key)] {
+			redacted = append(redacted, bson.E{Key: key, Value: "<redacted>"})
+		} else {
+			redacted = append(redacted, bson.E{Key: key, Value: redactValue(element.Value(), keys)})
+		}
+	}
+	return redacted
+}
+
+func redactValue(value bson.RawValue, keys map[string]bool) interface{} {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		return redact(value.Document(), keys)
+	case bsontype.Array:
+		values, _ := value.Array().Values()
+		array := make(bson.A, len(values))
+		for i, v := range values {
+			array[i] = redactValue(v, keys)
+		}
+		return array
+	}
+	return value
+}
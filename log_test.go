@@ -0,0 +1,71 @@
+package mgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type testLogger struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Output(calldepth int, s string) error {
+	l.m.Lock()
+	l.lines = append(l.lines, s)
+	l.m.Unlock()
+	return nil
+}
+
+func TestSetDebug(t *testing.T) {
+	Convey("commands are logged with redacted values when debugging", t, func() {
+		logger := &testLogger{}
+		SetLogger(logger)
+		defer SetLogger(nil)
+		opts := monitorLog(options.Client())
+		ctx := context.Background()
+		command, err := driverbson.Marshal(driverbson.D{
+			{Key: "updateUser", Value: "bob"},
+			{Key: "pwd", Value: "secret1"},
+			{Key: "roles", Value: driverbson.A{driverbson.D{{Key: "Password", Value: "secret2"}}}},
+			{Key: "token", Value: "secret3"},
+		})
+		So(err, ShouldBeNil)
+		started := &event.CommandStartedEvent{Command: command, DatabaseName: "admin", CommandName: "updateUser", RequestID: 7, ConnectionID: "db1:27017[-1]"}
+		finished := event.CommandFinishedEvent{DurationNanos: int64(3 * time.Millisecond), CommandName: "updateUser", RequestID: 7, ConnectionID: "db1:27017[-1]"}
+
+		opts.Monitor.Started(ctx, started)
+		So(logger.lines, ShouldBeEmpty)
+
+		SetDebug(true)
+		defer SetDebug(false)
+		opts.Monitor.Started(ctx, started)
+		opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished, Reply: command})
+		So(logger.lines, ShouldHaveLength, 2)
+		So(logger.lines[0], ShouldStartWith, "Command updateUser on admin started (request 7 on db1:27017[-1]): ")
+		So(logger.lines[0], ShouldContainSubstring, `"updateUser":"bob"`)
+		So(logger.lines[0], ShouldContainSubstring, `"token":"secret3"`)
+		So(logger.lines[0], ShouldNotContainSubstring, "secret1")
+		So(logger.lines[0], ShouldNotContainSubstring, "secret2")
+		So(logger.lines[1], ShouldEqual, fmt.Sprintf("Command updateUser on admin succeeded in 3ms (request 7 on db1:27017[-1]): reply of %d bytes", len(command)))
+
+		SetRedactedKeys("token")
+		defer SetRedactedKeys("password", "pwd", "payload")
+		opts.Monitor.Started(ctx, started)
+		opts.Monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished, Failure: "not authorized"})
+		So(logger.lines[2], ShouldContainSubstring, "secret1")
+		So(logger.lines[2], ShouldNotContainSubstring, "secret3")
+		So(logger.lines[3], ShouldEqual, "Command updateUser on admin failed in 3ms (request 7 on db1:27017[-1]): not authorized")
+
+		opts.Monitor.Started(ctx, &event.CommandStartedEvent{DatabaseName: "admin", CommandName: "saslStart"})
+		So(logger.lines[4], ShouldEndWith, ": <redacted>")
+	})
+}
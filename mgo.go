@@ -3,17 +3,26 @@ package mgo
 import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 type Mode = readpref.Mode
-type Safe = writeconcern.WriteConcern
 type Collation = options.Collation
 
-//const (
-//	Primary = readpref.PrimaryMode
-//	PrimaryPreferred = readpref.PrimaryPreferredMode
-//	Secondary = readpref.SecondaryMode
-//	SecondaryPreferred = readpref.SecondaryPreferredMode
-//	Nearest = readpref.NearestMode
-//)
+const (
+	// Relevant documentation on read preference modes:
+	//
+	//     http://docs.mongodb.org/manual/reference/read-preference/
+	//
+	Primary            Mode = readpref.PrimaryMode            // Default mode. All operations read from the current replica set primary.
+	PrimaryPreferred   Mode = readpref.PrimaryPreferredMode   // Read from the primary if available. Read from the secondary otherwise.
+	Secondary          Mode = readpref.SecondaryMode          // Read from one of the nearest secondary members of the replica set.
+	SecondaryPreferred Mode = readpref.SecondaryPreferredMode // Read from one of the nearest secondaries if available. Read from primary otherwise.
+	Nearest            Mode = readpref.NearestMode            // Read from one of the nearest members, irrespective of it being primary or secondary.
+
+	// Read preference modes specific to mgo, mapped to the closest modes
+	// above. Unlike with mgo, sessions in the Monotonic mode don't switch to
+	// the primary after their first write. See Session.SetMode.
+	Eventual  Mode = Nearest            // Same as Nearest.
+	Monotonic Mode = SecondaryPreferred // Same as SecondaryPreferred.
+	Strong    Mode = Primary            // Same as Primary.
+)
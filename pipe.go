@@ -1,11 +1,9 @@
 package mgo
 
 import (
-	"context"
 	"github.com/yaziming/mgo/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"time"
 )
 
@@ -19,8 +17,11 @@ func (p *Pipe) AllowDiskUse() *Pipe {
 	p.allowDisk = true
 	return p
 }
+
+// Batch sets the batch size used when fetching documents from the database.
+// See Session.SetBatch.
 func (p *Pipe) Batch(n int) *Pipe {
-	p.op.limit = n
+	p.batch = n
 	return p
 }
 func (p *Pipe) Collation(collation *Collation) *Pipe {
@@ -30,26 +31,38 @@ func (p *Pipe) Collation(collation *Collation) *Pipe {
 	return p
 }
 func (p *Pipe) Explain(result interface{}) error {
+	if p.coll.err != nil {
+		return p.coll.err
+	}
 	command := bson.D{
-		{"aggregate", p.coll.collection.Name()},
-		{"pipeline", p.pipeline},
-		{"explain", true},
+		{Key: "aggregate", Value: p.coll.collection.Name()},
+		{Key: "pipeline", Value: p.pipeline},
+		{Key: "explain", Value: true},
+	}
+	opts := options.RunCmd().SetReadPreference(p.coll.collection.Database().ReadPreference())
+	ctx, done, err := p.coll.begin(nil)
+	if err != nil {
+		return err
 	}
-	opts := options.RunCmd().SetReadPreference(readpref.Primary())
-	if err := p.coll.collection.Database().RunCommand(nil, command, opts).Decode(result); err != nil {
+	defer done()
+	if err := p.coll.collection.Database().RunCommand(ctx, command, opts).Decode(result); err != nil {
 		return err
 	}
 	return nil
 }
 func (p *Pipe) aggregate(others ...*options.AggregateOptions) (*mongo.Cursor, error) {
-	var ctx context.Context
-	if p.maxTimeMS > 0 {
-		ctx, _ = context.WithTimeout(context.Background(), time.Duration(p.maxTimeMS)*time.Millisecond)
+	if p.coll.err != nil {
+		return nil, p.coll.err
 	}
 	opts := p.toAggregateOptions()
 	for _, other := range others {
 		opts = options.MergeAggregateOptions(opts, other)
 	}
+	ctx, done, err := p.coll.begin(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	return p.coll.collection.Aggregate(ctx, p.pipeline, opts)
 }
 func (p *Pipe) All(result interface{}) error {
@@ -58,15 +71,11 @@ func (p *Pipe) All(result interface{}) error {
 	if err != nil {
 		return err
 	}
-	return cs.All(nil, result)
+	return p.coll.newIter(cs, nil).All(result)
 }
 func (p *Pipe) Iter() *Iter {
 	cs, err := p.aggregate()
-	return &Iter{
-		cursor: cs,
-		done:   false,
-		err:    err,
-	}
+	return p.coll.newIter(cs, err)
 }
 func (p *Pipe) One(result interface{}) (err error) {
 	iter := p.Iter()
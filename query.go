@@ -6,12 +6,14 @@ import (
 	"github.com/Masterminds/semver"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 )
 
 type op struct {
@@ -25,10 +27,23 @@ type op struct {
 }
 
 type query struct {
-	collation *Collation
-	maxTimeMS int64
-	op        op
-	allowDisk bool
+	collation       *Collation
+	maxTimeMS       int64
+	op              op
+	allowDisk       bool
+	batch           int
+	prefetch        float64
+	noCursorTimeout bool
+}
+
+// newQuery returns a query with the defaults of settings. See
+// Session.SetBatch, Session.SetPrefetch and Session.SetCursorTimeout.
+func newQuery(settings opSettings) query {
+	return query{
+		batch:           settings.batch,
+		prefetch:        settings.prefetch,
+		noCursorTimeout: settings.noCursorTimeout,
+	}
 }
 
 func (q *query) toFindAndDeleteOptions() *options.FindOneAndDeleteOptions {
@@ -37,7 +52,7 @@ func (q *query) toFindAndDeleteOptions() *options.FindOneAndDeleteOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 
 	if len(q.op.hint) > 0 {
@@ -59,7 +74,7 @@ func (q *query) toFindOneAndUpdateOptions() *options.FindOneAndUpdateOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 
 	if len(q.op.hint) > 0 {
@@ -79,7 +94,7 @@ func (q *query) toFindOneAndReplaceOptions() *options.FindOneAndReplaceOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 
 	if len(q.op.hint) > 0 {
@@ -101,10 +116,10 @@ func (q *query) toAggregateOptions() *options.AggregateOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
-	if q.op.limit > 0 {
-		opts.SetBatchSize(int32(q.op.limit))
+	if q.batch > 0 {
+		opts.SetBatchSize(int32(q.batch))
 	}
 
 	if len(q.op.hint) > 0 {
@@ -125,7 +140,7 @@ func (q *query) toFindOptions() *options.FindOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 
 	if q.op.skip > 0 {
@@ -146,6 +161,12 @@ func (q *query) toFindOptions() *options.FindOptions {
 	if q.op.limit > 0 {
 		opts.SetLimit(int64(q.op.limit))
 	}
+	if q.batch > 0 {
+		opts.SetBatchSize(int32(q.batch))
+	}
+	if q.noCursorTimeout {
+		opts.SetNoCursorTimeout(true)
+	}
 	if q.allowDisk {
 		opts.SetAllowDiskUse(q.allowDisk)
 	}
@@ -157,7 +178,7 @@ func (q *query) toDistinctOptions() *options.DistinctOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 	return opts
 }
@@ -167,7 +188,7 @@ func (q *query) toCountOptions() *options.CountOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 
 	if q.op.skip > 0 {
@@ -188,7 +209,7 @@ func (q *query) toFindOneOptions() *options.FindOneOptions {
 		opts.SetCollation(q.collation)
 	}
 	if q.maxTimeMS != 0 {
-		opts.SetMaxTime(time.Duration(q.maxTimeMS))
+		opts.SetMaxTime(time.Duration(q.maxTimeMS) * time.Millisecond)
 	}
 	if q.op.skip > 0 {
 		opts.SetSkip(int64(q.op.skip))
@@ -220,14 +241,27 @@ var (
 )
 
 func (qr *Query) AllowDiskUse() *Query {
-	if allowDiskUseConstraint.Check(qr.coll.db.version) {
+	if version := qr.coll.db.Version(); version != nil && allowDiskUseConstraint.Check(version) {
 		qr.query.allowDisk = true
 	}
 	return qr
 }
+
+// Batch sets the batch size used when fetching documents from the database.
+// See Session.SetBatch.
 func (qr *Query) Batch(n int) *Query {
+	if n == 1 {
+		// Server interprets 1 as -1 and closes the cursor (!?)
+		n = 2
+	}
+	qr.batch = n
+	return qr
+}
 
-	qr.Limit(n)
+// Prefetch sets the point at which the next batch of results will be
+// requested. See Session.SetPrefetch.
+func (qr *Query) Prefetch(p float64) *Query {
+	qr.prefetch = p
 	return qr
 }
 func (qr *Query) Collation(collation *Collation) *Query {
@@ -240,7 +274,12 @@ func (qr *Query) One(result interface{}) (err error) {
 		return
 	}
 	opts := qr.toFindOneOptions()
-	sg := qr.coll.collection.FindOne(nil, qr.op.filter, opts)
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	sg := qr.coll.collection.FindOne(ctx, qr.op.filter, opts)
 	if sg.Err() != nil {
 		return sg.Err()
 	}
@@ -305,7 +344,12 @@ func (qr *Query) cursor(others ...*options.FindOptions) (cur *mongo.Cursor, err
 	for _, other := range others {
 		opts = options.MergeFindOptions(opts, other)
 	}
-	cur, err = qr.coll.collection.Find(nil, qr.op.filter, opts)
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return
+	}
+	defer done()
+	cur, err = qr.coll.collection.Find(ctx, qr.op.filter, opts)
 	if err != nil {
 		return
 	}
@@ -333,24 +377,43 @@ func (qr *Query) Explain(result interface{}) error {
 	if qr.op.selector == nil {
 		qr.op.selector = bson.D{}
 	}
+	if qr.err != nil {
+		return qr.err
+	}
 	findCmd := bson.D{
-		{"find", qr.coll.collection.Name()},
-		{"filter", qr.op.filter},
+		{Key: "find", Value: qr.coll.collection.Name()},
+		{Key: "filter", Value: qr.op.filter},
 	}
 	if qr.op.limit > 0 {
 		findCmd = append(findCmd, bson.E{Key: "limit", Value: qr.op.limit})
 	}
-	command := bson.D{{"explain", findCmd}}
+	command := bson.D{{Key: "explain", Value: findCmd}}
 
-	opts := options.RunCmd().SetReadPreference(readpref.Primary())
-	if err := qr.coll.db.database.RunCommand(nil, command, opts).Decode(result); err != nil {
+	opts := options.RunCmd().SetReadPreference(qr.coll.collection.Database().ReadPreference())
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := qr.coll.db.database.RunCommand(ctx, command, opts).Decode(result); err != nil {
 		return err
 	}
 	return nil
 }
 func (qr *Query) Distinct(key string, result interface{}) (err error) {
 
-	docs, err := qr.coll.collection.Distinct(nil, key, qr.op.filter, qr.toDistinctOptions())
+	if qr.err != nil {
+		return qr.err
+	}
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+	docs, err := qr.coll.collection.Distinct(ctx, key, qr.op.filter, qr.toDistinctOptions())
+	if err != nil {
+		return err
+	}
 	resultsVal := reflect.ValueOf(result)
 	if resultsVal.Kind() != reflect.Ptr {
 		return errors.New("results argument must be a pointer to a slice")
@@ -381,10 +444,11 @@ func (qr *Query) All(result interface{}) (err error) {
 	if cur.Err() != nil {
 		return cur.Err()
 	}
+	iter := qr.coll.newIter(cur, nil)
 	if result == nil {
-		return
+		return iter.Close()
 	}
-	return cur.All(nil, result)
+	return iter.All(result)
 }
 
 func (qr *Query) Count() (int, error) {
@@ -393,7 +457,12 @@ func (qr *Query) Count() (int, error) {
 		return -1, qr.err
 	}
 	opts := qr.toCountOptions()
-	c, err := qr.coll.collection.CountDocuments(nil, qr.op.filter, opts)
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return -1, err
+	}
+	defer done()
+	c, err := qr.coll.collection.CountDocuments(ctx, qr.op.filter, opts)
 	if err != nil {
 		return -1, err
 	}
@@ -412,16 +481,22 @@ func (qr *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 		err = qr.err
 		return
 	}
+	ctx, done, err := qr.coll.begin(nil)
+	if err != nil {
+		return
+	}
+	defer done()
 	if change.Remove {
 		opts := qr.toFindAndDeleteOptions()
-		r := qr.coll.collection.FindOneAndDelete(context.Background(), qr.op.filter, opts)
+		r := qr.coll.collection.FindOneAndDelete(ctx, qr.op.filter, opts)
 		err = r.Err()
 		if err != nil {
 			return
 		}
-		err = r.Decode(&result)
-		if err != nil {
-			return
+		if result != nil {
+			if err = r.Decode(result); err != nil {
+				return
+			}
 		}
 
 		return &ChangeInfo{
@@ -438,10 +513,12 @@ func (qr *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 	} else {
 		uro.SetReturnDocument(options.Before)
 	}
-	replaceResult := qr.coll.collection.FindOneAndReplace(nil, qr.op.filter, change.Update, uro)
+	replaceResult := qr.coll.collection.FindOneAndReplace(ctx, qr.op.filter, change.Update, uro)
 	if replaceResult.Err() == nil {
-		if err = replaceResult.Decode(result); err != nil {
-			return nil, err
+		if result != nil {
+			if err = replaceResult.Decode(result); err != nil {
+				return nil, err
+			}
 		}
 		return &ChangeInfo{
 			Updated: 1,
@@ -462,15 +539,16 @@ func (qr *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 	} else {
 		umo.SetReturnDocument(options.Before)
 	}
-	r := qr.coll.collection.FindOneAndUpdate(context.Background(), qr.op.filter, change.Update, umo)
+	r := qr.coll.collection.FindOneAndUpdate(ctx, qr.op.filter, change.Update, umo)
 	if r.Err() != nil {
 		err = r.Err()
 		return
 	}
 
-	err = r.Decode(&result)
-	if err != nil {
-		return
+	if result != nil {
+		if err = r.Decode(result); err != nil {
+			return
+		}
 	}
 
 	return &ChangeInfo{
@@ -482,30 +560,282 @@ func (qr *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 
 func (qr *Query) Iter() *Iter {
 	cur, err := qr.cursor()
-	return &Iter{cursor: cur, err: err}
+	return qr.coll.newIter(cur, err)
+}
+
+// For works like Iter.For on the iterator of the query. See Query.Iter.
+func (qr *Query) For(result interface{}, f func() error) error {
+	return qr.Iter().For(result, f)
+}
+
+// Tail returns a tailable iterator over the documents matched by the
+// query, which must be on a capped collection. Unlike a regular iterator,
+// it isn't done once the last document is returned: Next blocks until a
+// new document is inserted, the timeout elapses or an error occurs. Once
+// Next returns false due to the timeout, as reported by Timeout, it may be
+// called again to continue the iteration. A negative timeout blocks
+// forever, and a zero timeout returns as soon as no document is available.
+//
+// The query is issued again when the server invalidates the cursor, as it
+// does when the collection is empty or the iterator fell behind the
+// insertions. The iteration then resumes from the position of the last
+// document returned in the natural order of the collection, skipping the
+// documents up to it, or from the first document if it was removed in the
+// meantime. This requires the _id of the documents to be returned: Next
+// returns false with ErrTailNoId if the query has to be issued again after
+// a document without _id.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/core/tailable-cursors/
+func (qr *Query) Tail(timeout time.Duration) *Iter {
+	tail := &tailing{query: *qr, timeout: timeout}
+	cur, err := tail.cursor()
+	iter := qr.coll.newIter(cur, err)
+	iter.tail = tail
+	return iter
+}
+
+// ErrTailNoId is returned by tailable iterators that can't resume after the
+// last document returned, as its _id was left out. See Query.Tail.
+var ErrTailNoId = errors.New("tailable iterator can't resume after a document without _id")
+
+// Cursor errors after which tailable iterators issue their query again.
+const (
+	cursorNotFound     = 43
+	cappedPositionLost = 136
+)
+
+// tailRetryDelay is the time a tailable iterator waits before issuing its
+// query again when the cursor returned no document.
+const tailRetryDelay = 100 * time.Millisecond
+
+// tailing holds the state of an iterator returned by Query.Tail.
+type tailing struct {
+	query   Query
+	timeout time.Duration
+	dead    bool
+
+	// started is set once a document was returned, and lastId then holds
+	// the _id of the last one, or noId is set if it had none. Once the query
+	// is issued again, seeking is set until the document with lastId is
+	// skipped.
+	started bool
+	lastId  *bson.Raw
+	noId    bool
+	seeking bool
+}
+
+// resumeQuery returns the query of the iterator, which is issued again
+// without skipping documents once some were returned.
+func (t *tailing) resumeQuery() *Query {
+	q := t.query
+	if t.started {
+		q.op.skip = 0
+	}
+	return &q
+}
+
+// returned records doc as the last document returned by the iterator.
+func (t *tailing) returned(doc driverbson.Raw) {
+	id, err := doc.LookupErr("_id")
+	t.started, t.noId = true, err != nil
+	t.lastId = nil
+	if err == nil {
+		t.lastId = &bson.Raw{Type: id.Type, Value: append([]byte(nil), id.Value...)}
+	}
+}
+
+// seek reports whether doc is to be skipped as the iterator resumes after
+// the last document returned.
+func (t *tailing) seek(doc driverbson.Raw) bool {
+	if !t.seeking {
+		return false
+	}
+	if id, err := doc.LookupErr("_id"); err == nil && id.Equal(*t.lastId) {
+		t.seeking = false
+	}
+	return true
+}
+
+// cursor issues the query of the iterator. See resumeQuery.
+func (t *tailing) cursor() (*mongo.Cursor, error) {
+	opts := options.Find().SetCursorType(options.Tailable)
+	if t.timeout != 0 {
+		opts.SetCursorType(options.TailableAwait)
+	}
+	if t.timeout > 0 {
+		opts.SetMaxAwaitTime(t.timeout)
+	}
+	return t.resumeQuery().cursor(opts)
 }
 
 type Iter struct {
-	cursor *mongo.Cursor
-	done   bool
-	err    error
+	cursor   *mongo.Cursor
+	ctx      context.Context
+	settings opSettings
+	limiter  *opLimiter
+	done     bool
+	err      error
+
+	tail     *tailing
+	timedOut bool
+
+	// The size of the current batch and the documents remaining in it.
+	batchSize int
+	remaining int
+
+	// The current document, and the documents received but not returned
+	// yet, which are returned before those of the cursor. See State.
+	// Documents of the driver cursor are decoded by it, and pending ones
+	// with the registry of the client.
+	doc        driverbson.Raw
+	docPending bool
+	pending    []driverbson.Raw
+	registry   *bsoncodec.Registry
+
+	// The cursor iterated with getMore commands when there is no driver
+	// cursor. See Collection.NewIter.
+	coll     *Collection
+	cursorId int64
+}
+
+// newIter returns an iterator over cur, fetching further batches with the
+// context and settings of the collection.
+func (c *Collection) newIter(cur *mongo.Cursor, err error) *Iter {
+	iter := &Iter{cursor: cur, ctx: c.opContext(nil), settings: c.db.settings, limiter: c.db.limiter, err: err, coll: c, registry: c.db.registry}
+	iter.newBatch()
+	return iter
+}
+
+// ErrIterUnbound is returned by iterators of Collection.NewIter resuming a
+// cursor in a session that isn't bound to a driver session.
+var ErrIterUnbound = errors.New("Collection.NewIter: cursors may only be resumed in sessions bound to a driver session")
+
+// NewIter returns an iterator resuming the cursor cursorId of the
+// collection, where firstBatch holds the documents received but not
+// returned yet, as reported by Iter.State. This allows an iteration to
+// continue in another goroutine, or in a later request of a paginated API.
+// If session is not nil, it's used instead of the session of c. When err
+// is not nil, the iterator fails with it, as if the query did.
+//
+// The server ties cursors to the driver session they were created in, so
+// they may only be resumed when created in a session bound to a driver
+// session, as with SetCausalConsistency or within a transaction, with a
+// session bound to the same one. Otherwise each operation runs in its own
+// implicit driver session, and NewIter fails with ErrIterUnbound unless the
+// cursor is exhausted. Further batches are requested from the primary, so
+// the cursor must have been created on it, as in the default Strong mode.
+//
+// The documents of firstBatch are decoded with the registry of the client
+// options the session was dialed with. For sessions created with
+// NewFromMongoDriver, whose client options are unknown, the default
+// registry is used.
+func (c *Collection) NewIter(session *Session, firstBatch []bson.Raw, cursorId int64, err error) *Iter {
+	if session != nil {
+		c = c.With(session)
+	}
+	if err == nil && cursorId != 0 && c.db.driverSession == nil {
+		err = ErrIterUnbound
+	}
+	iter := c.newIter(nil, err)
+	iter.cursorId = cursorId
+	for _, raw := range firstBatch {
+		iter.pending = append(iter.pending, driverbson.Raw(raw.Value))
+	}
+	iter.done = cursorId == 0 && len(iter.pending) == 0
+	iter.newBatch()
+	return iter
+}
+
+// State returns the id of the cursor of the iterator, or zero once it's
+// exhausted, and the documents received but not returned yet. Together
+// with Collection.NewIter, it allows resuming the iteration elsewhere; the
+// iterator must then be abandoned without being closed, as closing it
+// kills the cursor. The iterator itself may still be used.
+func (iter *Iter) State() (cursorId int64, batch []bson.Raw) {
+	if iter.cursor != nil {
+		// Move the documents of the driver cursor to pending ones, so they
+		// can be read without requesting the next batch.
+		for iter.cursor.RemainingBatchLength() > 0 && iter.cursor.TryNext(iter.ctx) {
+			iter.pending = append(iter.pending, append(driverbson.Raw(nil), iter.cursor.Current...))
+		}
+		cursorId = iter.cursor.ID()
+	} else {
+		cursorId = iter.cursorId
+	}
+	for _, doc := range iter.pending {
+		batch = append(batch, bson.Raw{Type: bsontype.EmbeddedDocument, Value: append([]byte(nil), doc...)})
+	}
+	return cursorId, batch
+}
+
+// begin starts a round trip of the iterator. See Database.begin.
+func (iter *Iter) begin() (context.Context, func(), error) {
+	return iter.settings.begin(iter.ctx, iter.limiter)
 }
 
 func (iter *Iter) All(result interface{}) error {
 	if iter.err != nil {
 		return iter.err
 	}
+	if iter.cursor == nil || len(iter.pending) > 0 || iter.tail != nil {
+		return iter.all(result)
+	}
 	if iter.err = iter.cursor.Err(); iter.err != nil {
 		return iter.err
 	}
-	iter.err = iter.cursor.All(nil, result)
+	ctx, done, err := iter.begin()
+	if err != nil {
+		iter.err = err
+		return err
+	}
+	defer done()
+	iter.err = iter.cursor.All(ctx, result)
 	return iter.err
 }
+
+// all works like All, decoding the documents one by one.
+func (iter *Iter) all(result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		iter.Close()
+		return errors.New("result argument must be a slice address")
+	}
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for {
+		elemp := reflect.New(elemt)
+		if !iter.Next(elemp.Interface()) {
+			break
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	resultv.Elem().Set(slicev)
+	return nil
+}
+
 func (iter *Iter) Close() error {
+	if iter.cursor == nil {
+		if iter.cursorId != 0 {
+			iter.killCursor()
+		}
+		return iter.err
+	}
+	ctx, done, err := iter.begin()
+	if err != nil {
+		// Kill the cursor anyway rather than leaving it to the server.
+		ctx, done = iter.ctx, func() {}
+	}
+	err = iter.cursor.Close(ctx)
+	done()
 	if iter.err != nil {
 		return iter.err
 	}
-	return iter.cursor.Close(nil)
+	return err
 }
 func (iter *Iter) Err() error {
 	return iter.err
@@ -514,15 +844,183 @@ func (iter *Iter) Err() error {
 func (iter *Iter) Done() bool {
 	return iter.err != nil || iter.done
 }
+
+// Timeout reports whether the last call to Next on a tailable iterator
+// returned false because no document was inserted before the timeout.
+// Next may then be called again. See Query.Tail.
+func (iter *Iter) Timeout() bool {
+	return iter.timedOut
+}
+
 func (iter *Iter) Next(result interface{}) bool {
-	if iter.err != nil {
+	if !iter.advance() {
 		return false
 	}
-	iter.done = !iter.cursor.Next(nil)
-	if !iter.done {
+	if iter.docPending {
+		iter.err = driverbson.UnmarshalWithRegistry(iter.registry, iter.doc, result)
+	} else {
 		iter.err = iter.cursor.Decode(result)
 	}
-	return !iter.done
+	return iter.err == nil
+}
+
+// NextRaw works like Next, returning the document undecoded so a few of
+// its fields may be looked up cheaply. The document is only valid until
+// the next call on the iterator, and must be copied to be retained.
+func (iter *Iter) NextRaw() (bson.Raw, bool) {
+	if !iter.advance() {
+		return bson.Raw{}, false
+	}
+	return bson.Raw{Type: bsontype.EmbeddedDocument, Value: iter.doc}, true
+}
+
+// For calls f after decoding each document into result, which is reset to
+// its zero value beforehand so values referenced by a document are never
+// shared with the next one. Iteration stops on the first error returned by
+// f, and the iterator is closed in all cases. For returns the error of f,
+// or else the error of the iterator.
+func (iter *Iter) For(result interface{}, f func() error) error {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		iter.Close()
+		return errors.New("For: result argument must be a non-nil pointer")
+	}
+	zero := reflect.Zero(v.Elem().Type())
+	for {
+		v.Elem().Set(zero)
+		if !iter.Next(result) {
+			break
+		}
+		if err := f(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// BatchSize returns the number of documents of the batch the iterator is
+// returning documents from, as received from the server.
+func (iter *Iter) BatchSize() int {
+	return iter.batchSize
+}
+
+// RemainingBatchLength returns the number of documents of the current
+// batch not returned yet. Next doesn't wait for the server until these
+// were returned.
+func (iter *Iter) RemainingBatchLength() int {
+	if iter.cursor == nil {
+		return len(iter.pending)
+	}
+	return len(iter.pending) + iter.cursor.RemainingBatchLength()
+}
+
+// advance moves the iterator to its next document, reporting whether
+// there is one.
+func (iter *Iter) advance() bool {
+	if iter.err != nil {
+		return false
+	}
+	if len(iter.pending) > 0 {
+		iter.doc, iter.pending = iter.pending[0], iter.pending[1:]
+		iter.docPending = true
+		if iter.tail != nil {
+			iter.tail.returned(iter.doc)
+		}
+		iter.advanced()
+		return true
+	}
+	if iter.tail != nil {
+		return iter.tailAdvance()
+	}
+	if iter.cursor == nil {
+		return iter.getMore()
+	}
+	ctx, done, err := iter.begin()
+	if err != nil {
+		iter.err = err
+		return false
+	}
+	iter.done = !iter.cursor.Next(ctx)
+	done()
+	if iter.done {
+		iter.err = iter.cursor.Err()
+		return false
+	}
+	iter.doc, iter.docPending = iter.cursor.Current, false
+	iter.advanced()
+	return true
+}
+
+// getMoreResult is the result of the getMore command.
+type getMoreResult struct {
+	Cursor struct {
+		Id        int64            `bson:"id"`
+		NextBatch []driverbson.Raw `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+// getMore works like advance for iterators without a driver cursor,
+// requesting the next batch of the cursor with the getMore command.
+func (iter *Iter) getMore() bool {
+	for len(iter.pending) == 0 {
+		if iter.cursorId == 0 {
+			iter.done = true
+			return false
+		}
+		cmd := bson.D{{Key: "getMore", Value: iter.cursorId}, {Key: "collection", Value: iter.coll.Name()}}
+		if iter.settings.batch > 0 {
+			cmd = append(cmd, bson.E{Key: "batchSize", Value: int32(iter.settings.batch)})
+		}
+		ctx, done, err := iter.begin()
+		if err != nil {
+			iter.err = err
+			return false
+		}
+		var result getMoreResult
+		err = iter.coll.db.database.RunCommand(ctx, cmd).Decode(&result)
+		done()
+		if err != nil {
+			iter.err = err
+			return false
+		}
+		iter.cursorId, iter.pending = result.Cursor.Id, result.Cursor.NextBatch
+	}
+	return iter.advance()
+}
+
+// killCursor kills the cursor of an iterator without a driver cursor,
+// with the killCursors command.
+func (iter *Iter) killCursor() {
+	cmd := bson.D{{Key: "killCursors", Value: iter.coll.Name()}, {Key: "cursors", Value: bson.A{iter.cursorId}}}
+	ctx, done, err := iter.begin()
+	if err != nil {
+		// Kill the cursor anyway rather than leaving it to the server.
+		ctx, done = iter.ctx, func() {}
+	}
+	err = iter.coll.db.database.RunCommand(ctx, cmd).Err()
+	done()
+	if err != nil {
+		debugf("Failed to kill cursor %d on %s: %v", iter.cursorId, iter.coll.FullName(), err)
+	}
+	iter.cursorId = 0
+}
+
+// newBatch records the first batch of the cursor of the iterator.
+func (iter *Iter) newBatch() {
+	iter.batchSize = iter.RemainingBatchLength()
+	iter.remaining = iter.batchSize
+}
+
+// advanced updates the batch information once the cursor moved to its
+// next document. The cursor had to fetch a new batch if as many documents
+// remain in its batch as before.
+func (iter *Iter) advanced() {
+	remaining := iter.RemainingBatchLength()
+	if remaining >= iter.remaining {
+		iter.batchSize = remaining + 1
+	}
+	iter.remaining = remaining
 }
 
 // QueryError is returned when a query fails
@@ -535,3 +1033,78 @@ type QueryError struct {
 func (err *QueryError) Error() string {
 	return err.Message
 }
+
+// tailAdvance works like advance for tailable iterators. See Query.Tail.
+func (iter *Iter) tailAdvance() bool {
+	t := iter.tail
+	iter.timedOut = false
+	var deadline time.Time
+	if t.timeout >= 0 {
+		deadline = time.Now().Add(t.timeout)
+	}
+	for first := true; ; first = false {
+		if t.dead {
+			if !first {
+				delay := tailRetryDelay
+				if !deadline.IsZero() && time.Until(deadline) < delay {
+					delay = time.Until(deadline)
+				}
+				select {
+				case <-iter.ctx.Done():
+					iter.err = iter.ctx.Err()
+					return false
+				case <-time.After(delay):
+				}
+			}
+			if t.noId {
+				iter.err = ErrTailNoId
+				return false
+			}
+			_ = iter.Close()
+			iter.cursor, iter.err = t.cursor()
+			if iter.err != nil {
+				return false
+			}
+			iter.newBatch()
+			t.dead = false
+			t.seeking = t.lastId != nil
+		}
+
+		ctx, done, err := iter.begin()
+		if err != nil {
+			iter.err = err
+			return false
+		}
+		ok := iter.cursor.TryNext(ctx)
+		done()
+		if ok && t.seek(iter.cursor.Current) {
+			continue
+		}
+		if ok {
+			iter.doc, iter.docPending = iter.cursor.Current, false
+			t.returned(iter.doc)
+			iter.advanced()
+			return true
+		}
+		err = iter.cursor.Err()
+		if err != nil && !isCommandCode(err, cursorNotFound) && !isCommandCode(err, cappedPositionLost) {
+			iter.err = err
+			return false
+		}
+		if err != nil {
+			debugf("Tailable cursor on %s invalidated: %v", t.query.coll.FullName(), err)
+		}
+		t.dead = err != nil || iter.cursor.ID() == 0
+		if err == nil && t.seeking {
+			// The last document returned was removed, so the documents
+			// skipped were all inserted after it. Return them from the
+			// start.
+			debugf("Tailable cursor on %s lost its position, resuming from the first document", t.query.coll.FullName())
+			t.lastId, t.seeking, t.dead = nil, false, true
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			iter.timedOut = true
+			return false
+		}
+	}
+}
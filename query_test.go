@@ -1,12 +1,19 @@
 package mgo
 
 import (
+	"errors"
 	"github.com/davecgh/go-spew/spew"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/yaziming/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestQuery_Select(t *testing.T) {
@@ -568,3 +575,294 @@ func TestQuery_CountHint(t *testing.T) {
 		_, err = coll.Find(M{"n": M{"$gt": 1}}).Hint("does_not_exists").Count()
 	})
 }
+
+func TestTailing_ResumeQuery(t *testing.T) {
+	Convey("tailable iterators stop skipping documents once some were returned", t, func() {
+		coll := unconnectedSession("mydb").DB("mydb").C("log")
+
+		tail := &tailing{query: *coll.Find(bson.M{"level": "error"}).Skip(2)}
+		So(tail.resumeQuery().op.filter, ShouldResemble, bson.M{"level": "error"})
+		So(tail.resumeQuery().op.skip, ShouldEqual, 2)
+
+		tail.started = true
+		So(tail.resumeQuery().op.filter, ShouldResemble, bson.M{"level": "error"})
+		So(tail.resumeQuery().op.skip, ShouldEqual, 0)
+		So(tail.query.op.skip, ShouldEqual, 2)
+	})
+}
+
+func TestTailing_Seek(t *testing.T) {
+	Convey("tailable iterators skip the documents up to the last one returned", t, func() {
+		coll := unconnectedSession("mydb").DB("mydb").C("log")
+		doc := func(id interface{}) driverbson.Raw {
+			data, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+			So(err, ShouldBeNil)
+			return data
+		}
+
+		tail := &tailing{query: *coll.Find(nil)}
+		So(tail.seek(doc(1)), ShouldBeFalse)
+
+		// Documents are returned in natural order, whatever their _id.
+		tail.returned(doc(3))
+		tail.returned(doc("a"))
+		tail.returned(doc(1))
+		So(tail.started, ShouldBeTrue)
+		So(tail.noId, ShouldBeFalse)
+		So(*tail.lastId, ShouldResemble, bson.Raw{Type: bsontype.Int32, Value: []byte{1, 0, 0, 0}})
+
+		tail.seeking = true
+		So(tail.seek(doc(3)), ShouldBeTrue)
+		So(tail.seek(doc("a")), ShouldBeTrue)
+		So(tail.seek(doc(int64(1))), ShouldBeTrue)
+		So(tail.seeking, ShouldBeTrue)
+		So(tail.seek(doc(1)), ShouldBeTrue)
+		So(tail.seeking, ShouldBeFalse)
+		So(tail.seek(doc(0)), ShouldBeFalse)
+
+		noId, err := bson.Marshal(bson.D{{Key: "n", Value: 1}})
+		So(err, ShouldBeNil)
+		tail.returned(noId)
+		So(tail.noId, ShouldBeTrue)
+		So(tail.lastId, ShouldBeNil)
+	})
+}
+
+func TestQuery_Tail(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("log")
+		So(coll.Create(&CollectionInfo{Capped: true, MaxBytes: 1 << 20}), ShouldBeNil)
+
+		// The cursor on the empty collection is dead until documents come.
+		iter := coll.Find(nil).Tail(0)
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Timeout(), ShouldBeTrue)
+		So(iter.Err(), ShouldBeNil)
+
+		So(coll.Insert(M{"_id": 1}, M{"_id": 2}), ShouldBeNil)
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 1)
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 2)
+		So(iter.Timeout(), ShouldBeFalse)
+
+		start := time.Now()
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Timeout(), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		blocking := coll.Find(nil).Tail(-1)
+		So(blocking.Next(&doc), ShouldBeTrue)
+		So(blocking.Next(&doc), ShouldBeTrue)
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			_ = coll.Insert(M{"_id": 3})
+		}()
+		So(blocking.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 3)
+		So(blocking.Close(), ShouldBeNil)
+
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 3)
+		So(coll.Insert(M{"_id": 0}), ShouldBeNil)
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 0)
+		So(iter.Close(), ShouldBeNil)
+
+		So(ctx.mongo.DB("mydb").C("plain").Insert(M{"_id": 1}), ShouldBeNil)
+		iter = ctx.mongo.DB("mydb").C("plain").Find(nil).Tail(time.Second)
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Err(), ShouldNotBeNil)
+	})
+}
+
+func TestQuery_For(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("mycoll")
+		for i := 0; i < 5; i++ {
+			So(coll.Insert(M{"_id": i, "n": i, "tags": []string{"t"}}), ShouldBeNil)
+		}
+
+		var doc M
+		var docs []M
+		err := coll.Find(nil).Sort("_id").For(&doc, func() error {
+			docs = append(docs, doc)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(docs, ShouldHaveLength, 5)
+		So(docs[0]["n"], ShouldEqual, 0)
+		So(docs[4]["n"], ShouldEqual, 4)
+
+		stop := errors.New("stop")
+		count := 0
+		iter := coll.Find(nil).Batch(2).Iter()
+		So(iter.For(&doc, func() error {
+			count++
+			if count == 3 {
+				return stop
+			}
+			return nil
+		}), ShouldEqual, stop)
+		So(count, ShouldEqual, 3)
+		So(iter.Next(&doc), ShouldBeFalse)
+
+		So(coll.Find(nil).For(doc, func() error { return nil }), ShouldNotBeNil)
+	})
+}
+
+func TestIter_NextRaw(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		coll := ctx.mongo.DB("mydb").C("mycoll")
+		for i := 0; i < 5; i++ {
+			So(coll.Insert(M{"_id": i, "n": i * 10}), ShouldBeNil)
+		}
+
+		iter := coll.Find(nil).Sort("_id").Batch(2).Iter()
+		So(iter.BatchSize(), ShouldEqual, 2)
+		So(iter.RemainingBatchLength(), ShouldEqual, 2)
+
+		var sizes, remaining, ns []int
+		for {
+			raw, ok := iter.NextRaw()
+			if !ok {
+				break
+			}
+			ns = append(ns, int(raw.Document().Lookup("n").Int32()))
+			sizes = append(sizes, iter.BatchSize())
+			remaining = append(remaining, iter.RemainingBatchLength())
+		}
+		So(iter.Close(), ShouldBeNil)
+		So(ns, ShouldResemble, []int{0, 10, 20, 30, 40})
+		So(sizes, ShouldResemble, []int{2, 2, 2, 2, 1})
+		So(remaining, ShouldResemble, []int{1, 0, 1, 0, 0})
+	})
+}
+
+func TestCollection_NewIter(t *testing.T) {
+	Convey("iterators resume from the documents of a state", t, func() {
+		coll := unconnectedSession("mydb").DB("mydb").C("mycoll")
+
+		var batch []bson.Raw
+		for i := 0; i < 3; i++ {
+			doc, err := bson.Marshal(bson.D{{Key: "_id", Value: i}})
+			So(err, ShouldBeNil)
+			batch = append(batch, bson.Raw{Type: bsontype.EmbeddedDocument, Value: doc})
+		}
+		iter := coll.NewIter(nil, batch, 0, nil)
+		So(iter.BatchSize(), ShouldEqual, 3)
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 0)
+		So(iter.RemainingBatchLength(), ShouldEqual, 2)
+
+		id, state := iter.State()
+		So(id, ShouldEqual, 0)
+		So(state, ShouldResemble, batch[1:])
+
+		var docs []M
+		So(coll.NewIter(nil, state, id, nil).All(&docs), ShouldBeNil)
+		So(docs, ShouldResemble, []M{{"_id": int32(1)}, {"_id": int32(2)}})
+		So(iter.Next(&doc), ShouldBeTrue)
+		So(doc.Id, ShouldEqual, 1)
+
+		iter = coll.NewIter(nil, nil, 0, nil)
+		So(iter.Done(), ShouldBeTrue)
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Close(), ShouldBeNil)
+
+		failed := errors.New("failed")
+		iter = coll.NewIter(nil, batch, 0, failed)
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Close(), ShouldEqual, failed)
+
+		// Cursors of implicit driver sessions can't be resumed.
+		iter = coll.NewIter(nil, batch, 42, nil)
+		So(iter.Next(&doc), ShouldBeFalse)
+		So(iter.Err(), ShouldEqual, ErrIterUnbound)
+	})
+}
+
+func TestIter_State(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo.Copy()
+		defer session.Close()
+		session.SetCausalConsistency(true)
+		coll := session.DB("mydb").C("mycoll")
+		for i := 0; i < 5; i++ {
+			So(coll.Insert(M{"_id": i}), ShouldBeNil)
+		}
+
+		iter := coll.Find(nil).Sort("_id").Batch(2).Iter()
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		So(iter.Next(&doc), ShouldBeTrue)
+		id, batch := iter.State()
+		So(id, ShouldNotEqual, 0)
+		So(batch, ShouldHaveLength, 1)
+
+		// Resume in another goroutine, on the same bound session.
+		ids := make(chan int)
+		go func() {
+			defer close(ids)
+			resumed := coll.NewIter(session, batch, id, nil)
+			for resumed.Next(&doc) {
+				ids <- doc.Id
+			}
+			_ = resumed.Close()
+		}()
+		var resumed []int
+		for id := range ids {
+			resumed = append(resumed, id)
+		}
+		So(resumed, ShouldResemble, []int{1, 2, 3, 4})
+
+		// Closing kills the cursor.
+		iter = coll.Find(nil).Batch(2).Iter()
+		id, batch = iter.State()
+		resumedIter := coll.NewIter(nil, batch, id, nil)
+		So(resumedIter.Close(), ShouldBeNil)
+		So(coll.NewIter(nil, nil, id, nil).Next(&doc), ShouldBeFalse)
+
+		// Iterators of unbound sessions fail rather than resume.
+		unbound := ctx.mongo.DB("mydb").C("mycoll")
+		iter = unbound.Find(nil).Batch(2).Iter()
+		id, batch = iter.State()
+		So(id, ShouldNotEqual, 0)
+		resumedIter = unbound.NewIter(nil, batch, id, nil)
+		So(resumedIter.Next(&doc), ShouldBeFalse)
+		So(resumedIter.Err(), ShouldEqual, ErrIterUnbound)
+		So(iter.Close(), ShouldBeNil)
+	})
+}
+
+type upperString string
+
+func TestCollection_NewIterRegistry(t *testing.T) {
+	Convey("pending documents are decoded with the registry of the client", t, func() {
+		decodeUpper := func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+			s, err := vr.ReadString()
+			val.SetString(strings.ToUpper(s))
+			return err
+		}
+		registry := driverbson.NewRegistryBuilder().
+			RegisterTypeDecoder(reflect.TypeOf(upperString("")), bsoncodec.ValueDecoderFunc(decodeUpper)).
+			Build()
+		session := unconnectedSession("mydb")
+		session.cluster.registry = registry
+		coll := session.DB("mydb").C("mycoll")
+
+		doc, err := bson.Marshal(bson.D{{Key: "name", Value: "ann"}})
+		So(err, ShouldBeNil)
+		iter := coll.NewIter(nil, []bson.Raw{{Type: bsontype.EmbeddedDocument, Value: doc}}, 0, nil)
+		var result struct{ Name upperString }
+		So(iter.Next(&result), ShouldBeTrue)
+		So(result.Name, ShouldEqual, "ANN")
+	})
+}
@@ -0,0 +1,181 @@
+package mgo
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Safe session safety mode. See SetSafe for details on the Safe type.
+//
+// The W parameter determines how many servers should confirm a write
+// before the operation is considered successful.  If set to 0 or 1,
+// the command will return as soon as the primary is done with the request.
+// If WTimeout is greater than zero, it determines how many milliseconds
+// to wait for the W servers to respond before returning an error.
+//
+// WMode may be set to "majority" or to the name of a custom write mode
+// defined in the replica set configuration, and takes precedence over W.
+//
+// FSync and J both ask the server to commit the write to the journal
+// before acknowledging it.
+//
+// RMode sets the read concern level for MongoDB 3.2+ ("majority",
+// "local" or "linearizable") of the operations in the session.
+type Safe struct {
+	W        int    // Min # of servers to ack before success
+	WMode    string // Write mode for MongoDB 2.0+ (e.g. "majority")
+	RMode    string // Read mode for MongoDB 3.2+ ("majority", "local", "linearizable")
+	WTimeout int    // Milliseconds to wait for W before timing out
+	FSync    bool   // Sync via the journal if present, or via data files sync otherwise
+	J        bool   // Sync via the journal if present
+}
+
+// writeConcern converts the safety mode into its driver representation.
+// A nil Safe results in unacknowledged writes.
+func (safe *Safe) writeConcern() *writeconcern.WriteConcern {
+	if safe == nil {
+		return writeconcern.New(writeconcern.W(0))
+	}
+	var opts []writeconcern.Option
+	switch {
+	case safe.WMode == "majority":
+		opts = append(opts, writeconcern.WMajority())
+	case safe.WMode != "":
+		opts = append(opts, writeconcern.WTagSet(safe.WMode))
+	case safe.W > 0:
+		opts = append(opts, writeconcern.W(safe.W))
+	}
+	if safe.WTimeout > 0 {
+		opts = append(opts, writeconcern.WTimeout(time.Duration(safe.WTimeout)*time.Millisecond))
+	}
+	if safe.J || safe.FSync {
+		opts = append(opts, writeconcern.J(true))
+	}
+	return writeconcern.New(opts...)
+}
+
+// readConcern returns the read concern requested by RMode, or nil.
+func (safe *Safe) readConcern() *readconcern.ReadConcern {
+	if safe == nil || safe.RMode == "" {
+		return nil
+	}
+	return readconcern.New(readconcern.Level(safe.RMode))
+}
+
+// safeFromConcerns converts driver concerns back into a safety mode.
+// It returns nil for unacknowledged write concerns.
+func safeFromConcerns(wc *writeconcern.WriteConcern, rc *readconcern.ReadConcern) *Safe {
+	safe := &Safe{}
+	if wc != nil {
+		if !wc.Acknowledged() {
+			return nil
+		}
+		switch w := wc.GetW().(type) {
+		case int:
+			safe.W = w
+		case string:
+			safe.WMode = w
+		}
+		safe.WTimeout = int(wc.GetWTimeout() / time.Millisecond)
+		safe.J = wc.GetJ()
+	}
+	if rc != nil {
+		safe.RMode = rc.GetLevel()
+	}
+	return safe
+}
+
+// Safe returns the current safety mode for the session.
+func (s *Session) Safe() (safe *Safe) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.safe != nil {
+		safe = &Safe{}
+		*safe = *s.safe
+	}
+	return
+}
+
+// SetSafe changes the session safety mode.
+//
+// If the safe parameter is nil, the session is put in unsafe mode, and writes
+// become fire-and-forget, without error checking.  The unsafe mode is faster
+// since operations won't hold on waiting for a confirmation.
+//
+// If the safe parameter is not nil, any changing query (insert, update, ...)
+// will be followed by a confirmation according to the write concern, and
+// any errors reported by the server are returned to the caller.
+//
+// The default is &Safe{}, meaning check for errors and use the default
+// behavior for all fields.
+//
+// Database and Collection values obtained from the session before the call
+// keep the safety mode they were created with.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/write-concern/
+func (s *Session) SetSafe(safe *Safe) {
+	s.m.Lock()
+	s.safe = nil
+	s.ensureSafe(safe)
+	s.m.Unlock()
+}
+
+// EnsureSafe compares the provided safety parameters with the ones
+// currently in use by the session and picks the most conservative
+// choice for each setting.
+//
+// That is:
+//
+//   - safe.WMode is always used if set.
+//   - safe.W is used if larger than the current W and WMode is empty.
+//   - safe.FSync is always used if true.
+//   - safe.J is used if FSync is false.
+//   - safe.WTimeout is used if set and smaller than the current WTimeout.
+//   - safe.RMode is used if no read concern is set yet.
+//
+// For example, the following statement will ensure the session is
+// at least checking for errors, without enforcing further constraints.
+// If a more conservative SetSafe or EnsureSafe call was previously done,
+// the following call will be ignored.
+//
+//	session.EnsureSafe(&mgo.Safe{})
+//
+// See also the SetSafe method for details on what each option means.
+func (s *Session) EnsureSafe(safe *Safe) {
+	s.m.Lock()
+	s.ensureSafe(safe)
+	s.m.Unlock()
+}
+
+func (s *Session) ensureSafe(safe *Safe) {
+	if safe == nil {
+		return
+	}
+	// Always work on a copy, since other sessions may share the value.
+	next := *safe
+	if s.safe != nil {
+		next = *s.safe
+		if safe.WMode != "" {
+			next.WMode = safe.WMode
+		} else if next.WMode == "" && safe.W > next.W {
+			next.W = safe.W
+		}
+		if safe.WTimeout > 0 && safe.WTimeout < next.WTimeout {
+			next.WTimeout = safe.WTimeout
+		}
+		if safe.FSync {
+			next.FSync = true
+			next.J = false
+		} else if safe.J && !next.FSync {
+			next.J = true
+		}
+		if next.RMode == "" {
+			next.RMode = safe.RMode
+		}
+	}
+	s.safe = &next
+}
@@ -0,0 +1,52 @@
+package mgo
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestSession_EnsureSafe(t *testing.T) {
+	Convey("safety modes are merged and converted to write concerns", t, func() {
+		session := unconnectedSession("test")
+		So(session.Safe(), ShouldResemble, &Safe{})
+
+		session.SetSafe(nil)
+		So(session.Safe(), ShouldBeNil)
+		So(session.DB("mydb").database.WriteConcern().Acknowledged(), ShouldBeFalse)
+
+		session.EnsureSafe(&Safe{W: 2, WTimeout: 2000})
+		So(session.Safe(), ShouldResemble, &Safe{W: 2, WTimeout: 2000})
+
+		session.EnsureSafe(&Safe{W: 1, WTimeout: 1000, J: true})
+		So(session.Safe(), ShouldResemble, &Safe{W: 2, WTimeout: 1000, J: true})
+
+		session.EnsureSafe(&Safe{WMode: "majority", FSync: true})
+		So(session.Safe(), ShouldResemble, &Safe{W: 2, WMode: "majority", WTimeout: 1000, FSync: true})
+
+		wc := session.Copy().DB("mydb").C("mycoll").collection.Database().WriteConcern()
+		So(wc.GetW(), ShouldEqual, "majority")
+		So(wc.GetWTimeout(), ShouldEqual, time.Second)
+		So(wc.GetJ(), ShouldBeTrue)
+
+		session.SetSafe(&Safe{W: 1})
+		So(session.Safe(), ShouldResemble, &Safe{W: 1})
+	})
+}
+
+func TestSession_SetSafeUnacknowledged(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo.Copy()
+		So(session.DB("mydb").C("mycoll").Insert(M{"_id": 1}), ShouldBeNil)
+
+		session.SetSafe(nil)
+		coll := session.DB("mydb").C("mycoll")
+		So(coll.Insert(M{"_id": 1}), ShouldBeNil)
+		So(coll.Update(M{"_id": 2}, M{"$set": M{"n": 1}}), ShouldBeNil)
+		So(coll.Remove(M{"_id": 2}), ShouldBeNil)
+
+		session.SetSafe(&Safe{W: 1})
+		err := session.DB("mydb").C("mycoll").Insert(M{"_id": 1})
+		So(IsDup(err), ShouldBeTrue)
+	})
+}
@@ -2,9 +2,10 @@ package mgo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/Masterminds/semver"
 	"github.com/yaziming/mgo/bson"
-	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 	"net"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
 )
 
 var (
@@ -34,13 +36,51 @@ type ReadPreference struct {
 	TagSets []bson.D
 }
 
+// readPref converts the preference into its driver representation.
+func (rp *ReadPreference) readPref() (*readpref.ReadPref, error) {
+	var opts []readpref.Option
+	if len(rp.TagSets) > 0 {
+		sets := make([]tag.Set, 0, len(rp.TagSets))
+		for _, tags := range rp.TagSets {
+			set := make(tag.Set, 0, len(tags))
+			for _, elem := range tags {
+				value, ok := elem.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid read preference tag %q: want a string value, got %T", elem.Key, elem.Value)
+				}
+				set = append(set, tag.Tag{Name: elem.Key, Value: value})
+			}
+			sets = append(sets, set)
+		}
+		opts = append(opts, readpref.WithTagSets(sets...))
+	}
+	return readpref.New(rp.Mode, opts...)
+}
+
 // Session session session
 type Session struct {
-	client    *mongo.Client
-	database  string
-	uri       string
-	m         sync.RWMutex
-	buildInfo BuildInfo
+	cluster  *cluster
+	database string
+	uri      string
+	m        sync.RWMutex
+	mode     Mode
+	tagSets  []bson.D
+	safe     *Safe
+	cred     *Credential
+	settings opSettings
+	ctx      context.Context
+	shared   bool
+
+	// driverSession is the driver session the operations of the session
+	// run within, if any. See Session.SetCausalConsistency and
+	// Session.RunTransaction.
+	driverSession mongo.Session
+	// causal is set when driverSession was started by
+	// SetCausalConsistency, and is then owned by the session unless shared.
+	causal bool
+	// causalErr holds the error starting the causally consistent driver
+	// session, reported by the operations of the session.
+	causalErr error
 }
 
 func (s *Session) Run(cmd interface{}, result interface{}) error {
@@ -51,73 +91,271 @@ func (s *Session) Run(cmd interface{}, result interface{}) error {
 //
 // Relevant documentation:
 //
-// 		https://docs.mongodb.com/manual/reference/connection-string/
+//	https://docs.mongodb.com/manual/reference/connection-string/
 func New(uri string) *Session {
 	session := &Session{
 		uri: uri,
 	}
 	return session
 }
+
+// Dial establishes a new session to the cluster identified by the given
+// connection string, waiting up to 10 seconds for the first server to
+// respond. Unless the socketTimeoutMS option is provided, the socket
+// timeout is one minute. See DialWithTimeout.
 func Dial(url string) (*Session, error) {
-	if !strings.HasPrefix(url, "mongodb://") && !strings.HasPrefix(url, "mongodb+srv://") {
-		url = "mongodb://" + url
+	info, err := dialInfoFromURL(url, 10*time.Second, time.Minute)
+	if err != nil {
+		return nil, err
 	}
-	session := New(url)
-	err := session.Connect()
+	return DialWithInfo(info)
+}
 
-	return session, err
+// DialWithTimeout works like Dial, but uses timeout as the amount of time
+// to wait for a server to respond when connecting and on follow up
+// operations, overriding the connectTimeoutMS option of the URL. A timeout
+// of zero uses the driver defaults.
+//
+// The URL is parsed with ParseURL. Connection strings it doesn't support,
+// such as mongodb+srv ones or those with TLS options, are handed to the
+// driver as is, as long as the driver knows all of their options.
+func DialWithTimeout(url string, timeout time.Duration) (*Session, error) {
+	info, err := dialInfoFromURL(url, timeout, 0)
+	if err != nil {
+		return nil, err
+	}
+	return DialWithInfo(info)
 }
+
+// NewFromMongoDriver returns a session using the provided driver client.
+//
+// The client remains owned by the caller: closing the session and all of
+// its copies does not disconnect it.
 func NewFromMongoDriver(m *mongo.Client, database string) *Session {
+	return newSession(newCluster(m, nil, false), database)
+}
+
+func newSession(cluster *cluster, database string) *Session {
+	defaults := cluster.client.Database(database)
 	session := &Session{
 		database: database,
-		client:   m,
+		cluster:  cluster,
+		safe:     safeFromConcerns(defaults.WriteConcern(), defaults.ReadConcern()),
 	}
 	return session
 }
 
+// Close terminates the session. It's a runtime error to use a session
+// after it has been closed.
+//
+// The underlying client is shared by the session and all the sessions
+// obtained from it via New, Copy and Clone, and is disconnected when the
+// last one of them is closed.
 func (s *Session) Close() {
+	s.m.Lock()
+	if s.cluster != nil {
+		if !s.shared {
+			s.endCausal()
+			s.cluster.Release()
+		}
+		s.cluster = nil
+	}
+	s.m.Unlock()
+}
+
+// WithContext returns a shallow copy of the session bound to ctx.
+//
+// Every operation issued through the returned session, including those of
+// the databases, collections, queries, iterators and GridFS files obtained
+// from it, runs with ctx, so cancelling ctx or reaching its deadline aborts
+// them. Methods that take an explicit context use it instead.
+//
+// The returned session shares the client reference of s: it does not need
+// to be closed and must not be used after s is closed. Its settings may be
+// changed without affecting s.
+func (s *Session) WithContext(ctx context.Context) *Session {
+	if ctx == nil {
+		panic("nil context")
+	}
+	s.m.RLock()
+	scopy := shallowCopy(s)
+	s.m.RUnlock()
+	scopy.ctx = ctx
+	return scopy
+}
+
+// Context returns the context bound to the session with WithContext, or
+// context.Background if there is none.
+func (s *Session) Context() context.Context {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return contextOrBackground(s.ctx)
+}
+
+// mongoCluster returns the cluster of the session. The caller must hold
+// the session lock.
+func (s *Session) mongoCluster() *cluster {
+	if s.cluster == nil {
+		panic("Session already closed")
+	}
+	return s.cluster
 }
 
 // Collection returns coll
 func (s *Session) C(collection string) *Collection {
-	s.m.Lock()
-	defer s.m.Unlock()
-	return s.DB(s.database).C(collection)
+	return s.DB("").C(collection)
 }
 
 // Connect session client
 func (s *Session) Connect() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	opt := options.Client().SetSocketTimeout(1 * time.Minute).SetConnectTimeout(1 * time.Minute).ApplyURI(s.uri)
-	client, err := mongo.Connect(ctx, opt)
-	if err != nil {
-		return err
-	}
-	connectionString, err := connstring.ParseAndValidate(s.uri)
-	if err != nil {
-		return err
-	}
-	s.database = connectionString.Database
-	s.client = client
-	s.buildInfo, err = s.BuildInfo()
+	return s.connect(10 * time.Second)
+}
+
+func (s *Session) connect(timeout time.Duration) error {
+	info, err := dialInfoFromURL(s.uri, timeout, time.Minute)
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.dial(info)
 }
 
 // Ping verifies that the client can connect to the topology.
 // If readPreference is nil then will use the client's default read
 // preference.
 func (s *Session) Ping() error {
-	return s.client.Ping(context.TODO(), readpref.Primary())
+	client, ctx, done, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// begin starts an operation run on the client of the session. See
+// Database.begin.
+func (s *Session) begin() (*mongo.Client, context.Context, func(), error) {
+	s.m.RLock()
+	cluster, ctx, settings := s.mongoCluster(), contextOrBackground(s.ctx), s.settings
+	sess, err := s.driverSession, s.causalErr
+	s.m.RUnlock()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, done, err := settings.begin(withDriverSession(ctx, sess), cluster.opLimiter())
+	return cluster.client, ctx, done, err
 }
 
-// DB returns a value representing the named db.
+// DB returns a value representing the named db. If name is empty, the
+// database name provided in the dialed URL is used instead. If that is also
+// empty, "test" is used.
+//
+// The returned value uses the read preference and safety mode the session
+// has at the time of the call. See Session.SetMode, Session.SelectServers
+// and Session.SetSafe.
 func (s *Session) DB(db string) *Database {
-	version, _ := semver.NewVersion(s.buildInfo.Version)
-	return &Database{version: version, database: s.client.Database(db)}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if db == "" {
+		db = s.database
+	}
+	if db == "" {
+		db = "test"
+	}
+	opts := options.Database().SetWriteConcern(s.safe.writeConcern())
+	if rc := s.safe.readConcern(); rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	rp, err := s.readPref()
+	if rp != nil {
+		opts.SetReadPreference(rp)
+	}
+	if err == nil {
+		err = s.causalErr
+	}
+	cluster := s.mongoCluster()
+	return &Database{
+		session:  s,
+		database: cluster.client.Database(db, opts),
+		settings: s.settings,
+		limiter:  cluster.opLimiter(),
+		registry: cluster.registry,
+		ctx:      s.ctx,
+		err:      err,
+
+		driverSession: s.driverSession,
+	}
+}
+
+// SetMode changes the consistency mode for the session.
+//
+// The default mode is Strong, which sends all reads to the primary.
+// In the Secondary and SecondaryPreferred modes reads may be sent to
+// secondaries, which is useful for reporting and batch jobs that can
+// tolerate slightly stale data. The Nearest mode reads from the member
+// with the lowest latency, irrespective of it being a primary or a
+// secondary.
+//
+// The Monotonic mode of mgo is the SecondaryPreferred mode. The driver
+// selects a server per operation instead of switching the session to the
+// primary after its first write, so reads sent to secondaries may not
+// observe the preceding writes of the session. Enable causal consistency
+// with SetCausalConsistency for them to.
+//
+// The refresh parameter is accepted for compatibility with mgo. The driver
+// checks out a connection per operation, so there are no reserved sockets
+// to release and the mode is always applied to the following operations.
+//
+// Database and Collection values obtained from the session before the call
+// keep the mode they were created with.
+func (s *Session) SetMode(consistency Mode, refresh bool) {
+	s.m.Lock()
+	s.mode = consistency
+	s.m.Unlock()
+}
+
+// Mode returns the current consistency mode for the session.
+func (s *Session) Mode() Mode {
+	s.m.RLock()
+	mode := s.mode
+	s.m.RUnlock()
+	if mode == 0 {
+		return Primary
+	}
+	return mode
+}
+
+// SelectServers restricts communication to servers configured with the
+// given tags. For example, the following statement restricts servers
+// used for reading operations to those with both tag "disk" set to
+// "ssd" and tag "rack" set to 1:
+//
+//	session.SelectServers(bson.D{{"disk", "ssd"}, {"rack", "1"}})
+//
+// Multiple sets of tags may be provided, in which case the used server
+// must match all tags within any one set.
+//
+// Tags are not allowed with the Primary mode.
+//
+// Relevant documentation:
+//
+//	http://docs.mongodb.org/manual/tutorial/configure-replica-set-tag-sets
+func (s *Session) SelectServers(tags ...bson.D) {
+	s.m.Lock()
+	s.tagSets = tags
+	s.m.Unlock()
+}
+
+// readPref returns the driver read preference for the session, or nil when
+// neither a mode nor tags were set and the client default applies.
+func (s *Session) readPref() (*readpref.ReadPref, error) {
+	if s.mode == 0 && len(s.tagSets) == 0 {
+		return nil, nil
+	}
+	rp := ReadPreference{Mode: s.mode, TagSets: s.tagSets}
+	if rp.Mode == 0 {
+		rp.Mode = Primary
+	}
+	return rp.readPref()
 }
 
 type BuildInfo struct {
@@ -140,8 +378,12 @@ type ChangeInfo struct {
 }
 
 func (s *Session) BuildInfo() (info BuildInfo, err error) {
-
-	result := s.client.Database("admin").RunCommand(context.Background(), bson.M{"buildInfo": "1"})
+	client, ctx, done, err := s.begin()
+	if err != nil {
+		return
+	}
+	defer done()
+	result := client.Database("admin").RunCommand(ctx, bson.M{"buildInfo": "1"})
 	err = result.Decode(&info)
 	if err != nil {
 		return
@@ -170,17 +412,94 @@ func (s *Session) BuildInfo() (info BuildInfo, err error) {
 }
 
 func (s *Session) DatabaseNames() (names []string, err error) {
-	return s.client.ListDatabaseNames(context.Background(), bson.M{}, options.ListDatabases().SetNameOnly(true))
+	client, ctx, done, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return client.ListDatabaseNames(ctx, bson.M{}, options.ListDatabases().SetNameOnly(true))
 }
 
+// New creates a new session with the same parameters as the original
+// session, including consistency, safety mode and default database.
+// The parameters of the two sessions may be changed independently.
+// Credentials established with Login are not kept: the new session is
+// authenticated with the credentials used when dialing.
+//
+// The returned session shares the underlying client with the original one
+// and must be closed when no longer needed.
+func (s *Session) New() *Session {
+	s.m.RLock()
+	scopy := copySession(s, false)
+	s.m.RUnlock()
+	return scopy
+}
+
+// Copy works just like New, but preserves the exact authentication
+// information from the original session. It is the usual way of
+// obtaining a session per request or goroutine:
+//
+//	session := globalSession.Copy()
+//	defer session.Close()
 func (s *Session) Copy() *Session {
-	s.m.Lock()
-	defer s.m.Unlock()
+	s.m.RLock()
+	scopy := copySession(s, true)
+	s.m.RUnlock()
+	return scopy
+}
+
+// Clone works just like Copy. It is kept for compatibility with mgo, where
+// the clone reused the socket of the original session.
+func (s *Session) Clone() *Session {
+	s.m.RLock()
+	scopy := copySession(s, true)
+	s.m.RUnlock()
+	return scopy
+}
+
+// copySession returns a new session holding its own reference to the
+// cluster of session. Unless keepCreds is set, the new session uses the
+// dialed cluster rather than the one of a previous Login. The caller must
+// hold the session lock.
+func copySession(session *Session, keepCreds bool) *Session {
+	scopy := shallowCopy(session)
+	if !keepCreds && scopy.cluster.root != nil {
+		// The driver session belongs to the client being left.
+		scopy.cluster = scopy.cluster.rootCluster()
+		scopy.cred = nil
+		scopy.driverSession = nil
+	}
+	scopy.cluster.Acquire()
+	scopy.shared = false
+	if scopy.causal {
+		// Driver sessions are not safe for concurrent use, so each copy
+		// gets its own, continuing from where the original one is.
+		scopy.driverSession, scopy.causalErr = startCausalSession(scopy.cluster, session.driverSession)
+	}
+	return scopy
+}
+
+// shallowCopy returns a new session with the settings of session, sharing
+// its reference to the cluster. The caller must hold the session lock.
+func shallowCopy(session *Session) *Session {
+	if session.cluster == nil {
+		panic("Session already closed")
+	}
 	return &Session{
-		client:   s.client,
-		database: s.database,
-		uri:      s.uri,
-		m:        sync.RWMutex{},
+		cluster:  session.cluster,
+		database: session.database,
+		uri:      session.uri,
+		mode:     session.mode,
+		tagSets:  session.tagSets,
+		safe:     session.safe,
+		cred:     session.cred,
+		settings: session.settings,
+		ctx:      session.ctx,
+		shared:   true,
+
+		driverSession: session.driverSession,
+		causal:        session.causal,
+		causalErr:     session.causalErr,
 	}
 }
 
@@ -191,9 +510,9 @@ type DialInfo struct {
 	Addrs []string
 
 	// Timeout is the amount of time to wait for a server to respond when
-	// first connecting and on follow up operations in the session. If
-	// timeout is zero, the call may block forever waiting for a connection
-	// to be established. Timeout does not affect logic in DialServer.
+	// first connecting and on follow up operations in the session. It bounds
+	// both connection establishment and server selection. If timeout is zero,
+	// the driver defaults apply. Timeout does not affect logic in DialServer.
 	Timeout time.Duration
 
 	// Database is the default db name used when the Session.DB method
@@ -222,7 +541,8 @@ type DialInfo struct {
 	ServiceHost string
 
 	// Mechanism defines the protocol for credential negotiation.
-	// Defaults to "MONGODB-CR".
+	// If empty, the mechanism is negotiated with the server.
+	// See Credential.Mechanism for the supported values.
 	Mechanism string
 
 	// Username and Password inform the credentials for the initial authentication
@@ -230,8 +550,10 @@ type DialInfo struct {
 	Username string
 	Password string
 
-	// PoolLimit defines the per-server socket pool limit. Defaults to
-	// DefaultConnectionPoolLimit. See Session.SetPoolLimit for details.
+	// PoolLimit defines the per-server socket pool limit of the client.
+	// Zero keeps the default of the driver. Operations waiting for a
+	// connection are bounded by the driver; to also limit the operations
+	// of a session client-side, see Session.SetPoolLimit.
 	PoolLimit int
 
 	// PoolTimeout defines max time to wait for a connection to become available
@@ -272,6 +594,11 @@ type DialInfo struct {
 	// Safe mostly defines write options, though there is RMode. See Session.SetSafe
 	Safe Safe
 
+	// Unacknowledged puts sessions in unsafe mode, as SetSafe(nil) does,
+	// so writes are fire-and-forget whatever the write options of Safe. It's
+	// set for w=0 by ParseURL.
+	Unacknowledged bool
+
 	// FailFast will cause connection and query attempts to fail faster when
 	// the server is unavailable, instead of retrying until the configured
 	// timeout period. Note that an unavailable server may silently drop
@@ -292,65 +619,181 @@ type DialInfo struct {
 	// before being removed and closed.
 	MaxIdleTimeMS int
 	DialServer    func(addr *ServerAddr) (net.Conn, error)
-}
-type ServerAddr struct {
-	str string
-	tcp *net.TCPAddr
+
+	// uri is the connection string Dial obtained the DialInfo from when
+	// ParseURL doesn't support it. The client options are then obtained
+	// from uri, and only the timeouts of the DialInfo apply.
+	uri string
 }
 
-// DialWithInfo establishes a new session to the cluster identified by info.
-func DialWithInfo(dialInfo *DialInfo) (session *Session, err error) {
+// ClientOptions translates info into the driver options used by DialWithInfo.
+// It returns an error if info holds values that cannot be honored.
+func (info *DialInfo) ClientOptions() (*options.ClientOptions, error) {
+	if len(info.Addrs) == 0 && info.uri == "" {
+		return nil, errors.New("no servers in DialInfo.Addrs")
+	}
+	switch {
+	case info.Timeout < 0:
+		return nil, errors.New("negative DialInfo.Timeout")
+	case info.ReadTimeout < 0 || info.WriteTimeout < 0:
+		return nil, errors.New("negative DialInfo.ReadTimeout or DialInfo.WriteTimeout")
+	case info.PoolLimit < 0 || info.MinPoolSize < 0:
+		return nil, errors.New("negative DialInfo.PoolLimit or DialInfo.MinPoolSize")
+	case info.MaxIdleTimeMS < 0:
+		return nil, errors.New("negative DialInfo.MaxIdleTimeMS")
+	case info.PoolTimeout < 0:
+		return nil, errors.New("negative DialInfo.PoolTimeout")
+	}
+	if info.PoolLimit > 0 && info.MinPoolSize > info.PoolLimit {
+		return nil, fmt.Errorf("DialInfo.MinPoolSize (%d) exceeds DialInfo.PoolLimit (%d)", info.MinPoolSize, info.PoolLimit)
+	}
 
-	defaultOptions := options.Client().
-		SetMinPoolSize(uint64(dialInfo.MinPoolSize)).
-		SetMaxConnIdleTime(time.Duration(dialInfo.MaxIdleTimeMS)).
-		SetAppName(dialInfo.AppName).SetConnectTimeout(dialInfo.Timeout)
-	socketTimeout := dialInfo.ReadTimeout
-	if dialInfo.WriteTimeout > socketTimeout {
-		socketTimeout = dialInfo.WriteTimeout
+	opts := options.Client()
+	if info.uri != "" {
+		opts.ApplyURI(info.uri)
+	} else {
+		addrs := make([]string, len(info.Addrs))
+		copy(addrs, info.Addrs)
+		opts.SetHosts(addrs)
+	}
+	if info.Timeout > 0 {
+		opts.SetConnectTimeout(info.Timeout)
+		if opts.ServerSelectionTimeout == nil {
+			opts.SetServerSelectionTimeout(info.Timeout)
+		}
+	}
+	readTimeout, writeTimeout := info.ReadTimeout, info.WriteTimeout
+	if readTimeout == 0 {
+		readTimeout = info.Timeout
+	}
+	if writeTimeout == 0 {
+		writeTimeout = info.Timeout
+	}
+	// The driver has a single socket timeout covering both directions.
+	socketTimeout := readTimeout
+	if writeTimeout > socketTimeout {
+		socketTimeout = writeTimeout
 	}
 	if socketTimeout > 0 {
-		defaultOptions.SetSocketTimeout(dialInfo.ReadTimeout)
-	}
-	if dialInfo.PoolLimit > 0 {
-		defaultOptions.SetMaxPoolSize(uint64(dialInfo.PoolLimit))
-	}
-	if !dialInfo.FailFast {
-		defaultOptions.SetRetryReads(true)
-		defaultOptions.SetRetryWrites(true)
-	}
-	if dialInfo.ReadPreference != nil {
-		panic("dialInfo.ReadPreference dont implatement")
-	}
-	addrs := make([]string, len(dialInfo.Addrs))
-	copy(addrs, dialInfo.Addrs)
-	defaultOptions.SetHosts(addrs)
-	if dialInfo.Username != "" && dialInfo.Password != "" {
-		defaultOptions.SetAuth(options.Credential{
-			AuthSource:  dialInfo.Source,
-			Username:    dialInfo.Username,
-			Password:    dialInfo.Password,
-			PasswordSet: false,
-		})
-	}
-	if dialInfo.DialServer != nil {
+		opts.SetSocketTimeout(socketTimeout)
+	}
+	if info.uri != "" {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+		return opts, nil
+	}
+	if info.ReplicaSetName != "" {
+		opts.SetReplicaSet(info.ReplicaSetName)
+	}
+	if info.Direct {
+		opts.SetDirect(true)
+	}
+	if info.PoolLimit > 0 {
+		opts.SetMaxPoolSize(uint64(info.PoolLimit))
+	}
+	if info.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(info.MinPoolSize))
+	}
+	if info.MaxIdleTimeMS > 0 {
+		opts.SetMaxConnIdleTime(time.Duration(info.MaxIdleTimeMS) * time.Millisecond)
+	}
+	if info.AppName != "" {
+		opts.SetAppName(info.AppName)
+	}
+	if !info.FailFast {
+		opts.SetRetryReads(true)
+		opts.SetRetryWrites(true)
+	}
+	safe := info.Safe
+	if info.Unacknowledged {
+		opts.SetWriteConcern((*Safe)(nil).writeConcern())
+	} else {
+		opts.SetWriteConcern(safe.writeConcern())
+	}
+	if rc := safe.readConcern(); rc != nil {
+		opts.SetReadConcern(rc)
+	}
+	if info.ReadPreference != nil {
+		rp, err := info.ReadPreference.readPref()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+	if info.Username != "" || info.Mechanism != "" {
+		cred := Credential{
+			Username:    info.Username,
+			Password:    info.Password,
+			Source:      info.Source,
+			Service:     info.Service,
+			ServiceHost: info.ServiceHost,
+			Mechanism:   info.Mechanism,
+		}
+		auth, err := cred.auth(info.Database)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetAuth(auth)
+	}
+	if info.DialServer != nil {
+		dialServer := info.DialServer
 		var dialer topology.DialerFunc
 		dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
-			tcpAddr, err := resolveAddr(address)
+			tcpAddr, err := resolveAddr(ctx, address)
 			if err != nil {
 				return nil, err
 			}
-			return dialInfo.DialServer(&ServerAddr{
+			return dialServer(&ServerAddr{
 				str: address,
 				tcp: tcpAddr,
 			})
 		}
-		defaultOptions.SetDialer(dialer)
+		opts.SetDialer(dialer)
 	}
-	client, err := mongo.Connect(context.TODO(), defaultOptions)
-	if err != nil {
-		return
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// DialWithInfo establishes a new session to the cluster identified by info,
+// failing if no server responds within info.Timeout.
+func DialWithInfo(dialInfo *DialInfo) (*Session, error) {
+	session := &Session{}
+	if err := session.dial(dialInfo); err != nil {
+		return nil, err
 	}
-	session = NewFromMongoDriver(client, dialInfo.Database)
 	return session, nil
 }
+
+// dial connects s to the cluster identified by info.
+func (s *Session) dial(info *DialInfo) error {
+	opts, err := info.ClientOptions()
+	if err != nil {
+		return err
+	}
+	cluster, err := connectCluster(context.TODO(), opts)
+	if err != nil {
+		return err
+	}
+	safe := info.Safe
+	s.cluster = cluster
+	s.database = info.Database
+	s.safe = &safe
+	if info.Unacknowledged {
+		s.safe = nil
+	}
+	s.settings.poolTimeout = info.PoolTimeout
+	if info.ReadPreference != nil {
+		s.mode = info.ReadPreference.Mode
+		s.tagSets = info.ReadPreference.TagSets
+	}
+	buildInfo, err := s.BuildInfo()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	cluster.version, _ = semver.NewVersion(buildInfo.Version)
+	return nil
+}
@@ -1,11 +1,18 @@
 package mgo
 
 import (
+	"context"
+	"errors"
 	"github.com/smartystreets/assertions/should"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSession_Dial(t *testing.T) {
@@ -50,3 +57,176 @@ func TestSession_BuildInfo(t *testing.T) {
 
 	})
 }
+
+func TestSession_SetMode(t *testing.T) {
+	Convey("read preferences are applied to the databases of a session", t, func() {
+		session := unconnectedSession("test")
+		So(session.Mode(), ShouldEqual, Primary)
+
+		session.SetMode(Secondary, true)
+		So(session.Mode(), ShouldEqual, Secondary)
+		rp := session.DB("mydb").database.ReadPreference()
+		So(rp.Mode(), ShouldEqual, readpref.SecondaryMode)
+
+		session.SelectServers(bson.D{{Key: "disk", Value: "ssd"}, {Key: "rack", Value: "1"}})
+		coll := session.DB("mydb").C("mycoll")
+		So(coll.err, ShouldBeNil)
+		rp = coll.collection.Database().ReadPreference()
+		So(rp.TagSets(), ShouldResemble, []tag.Set{{{Name: "disk", Value: "ssd"}, {Name: "rack", Value: "1"}}})
+
+		session.SetMode(Strong, false)
+		err := session.DB("mydb").C("mycoll").Find(nil).One(nil)
+		So(err, ShouldErrorMatche, "can not specify tags.*")
+	})
+}
+
+func TestSession_SetModeMonotonic(t *testing.T) {
+	Convey("the Monotonic mode reads from secondaries without binding a driver session", t, func() {
+		session := unreachableSession("mydb")
+		defer session.Close()
+
+		session.SetMode(Monotonic, true)
+		So(session.Mode(), ShouldEqual, SecondaryPreferred)
+		So(session.CausalConsistency(), ShouldBeFalse)
+		coll := session.DB("mydb").C("mycoll")
+		So(coll.err, ShouldBeNil)
+		So(coll.collection.Database().ReadPreference().Mode(), ShouldEqual, readpref.SecondaryPreferredMode)
+		So(mongo.SessionFromContext(coll.opContext(nil)), ShouldBeNil)
+
+		So(func() { session.WithContext(context.Background()).SetMode(Monotonic, true) }, ShouldNotPanic)
+		err := session.RunTransaction(func(tx *Session) error {
+			So(func() { tx.SetMode(Monotonic, true) }, ShouldNotPanic)
+			return errors.New("abort")
+		}, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSession_SetModeSecondaryRead(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo
+		So(session.DB("mydb").C("mycoll").Insert(M{"a": 1}), ShouldBeNil)
+
+		session.SetMode(PrimaryPreferred, true)
+		var result struct{ A int }
+		err := session.DB("mydb").C("mycoll").Find(nil).One(&result)
+		So(err, ShouldBeNil)
+		So(result.A, ShouldEqual, 1)
+
+		iter := session.DB("mydb").C("mycoll").Pipe([]M{{"$match": M{"a": 1}}}).Iter()
+		So(iter.Next(&result), ShouldBeTrue)
+		So(iter.Close(), ShouldBeNil)
+	})
+}
+
+func TestSession_CopyClose(t *testing.T) {
+	Convey("copies share a reference counted client", t, func() {
+		session := unconnectedSession("mydb")
+		session.SetMode(Secondary, false)
+		session.SetSafe(&Safe{W: 2})
+
+		scopy := session.Copy()
+		clone := session.Clone()
+		fresh := session.New()
+		So(session.cluster.references, ShouldEqual, 4)
+		So(scopy.Mode(), ShouldEqual, Secondary)
+		So(clone.Safe(), ShouldResemble, &Safe{W: 2})
+		So(fresh.DB("").database.Name(), ShouldEqual, "mydb")
+
+		scopy.SetMode(Primary, false)
+		So(session.Mode(), ShouldEqual, Secondary)
+
+		scopy.Close()
+		scopy.Close()
+		clone.Close()
+		So(session.cluster.references, ShouldEqual, 2)
+		So(func() { scopy.DB("mydb") }, ShouldPanicWith, "Session already closed")
+		So(func() { scopy.Copy() }, ShouldPanicWith, "Session already closed")
+
+		db := fresh.DB("other")
+		So(db.Session(), ShouldEqual, fresh)
+		db.Close()
+		So(session.cluster.references, ShouldEqual, 1)
+	})
+}
+
+func TestSession_CopyVersion(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		scopy := ctx.mongo.Copy()
+		So(scopy.DB("mydb").Version(), ShouldNotBeNil)
+		So(scopy.DB("mydb").Version(), ShouldResemble, ctx.mongo.DB("mydb").Version())
+		scopy.Close()
+
+		So(ctx.mongo.Ping(), ShouldBeNil)
+		So(ctx.mongo.DB("").database.Name(), ShouldEqual, "test")
+	})
+}
+
+func TestDialInfo_ClientOptions(t *testing.T) {
+	Convey("every DialInfo field is translated", t, func() {
+		info := &DialInfo{
+			Addrs:          []string{"db1:27017"},
+			Timeout:        5 * time.Second,
+			ReplicaSetName: "rs0",
+			PoolLimit:      20,
+			MinPoolSize:    2,
+			MaxIdleTimeMS:  3000,
+			ReadTimeout:    time.Second,
+			WriteTimeout:   2 * time.Second,
+			AppName:        "reports",
+			ReadPreference: &ReadPreference{Mode: Secondary, TagSets: []bson.D{{{Key: "dc", Value: "east"}}}},
+			Safe:           Safe{W: 2, WTimeout: 100, J: true},
+			Direct:         true,
+		}
+		opts, err := info.ClientOptions()
+		So(err, ShouldBeNil)
+		So(opts.Hosts, ShouldResemble, []string{"db1:27017"})
+		So(*opts.ConnectTimeout, ShouldEqual, 5*time.Second)
+		So(*opts.ServerSelectionTimeout, ShouldEqual, 5*time.Second)
+		So(*opts.SocketTimeout, ShouldEqual, 2*time.Second)
+		So(*opts.ReplicaSet, ShouldEqual, "rs0")
+		So(*opts.Direct, ShouldBeTrue)
+		So(*opts.MaxPoolSize, ShouldEqual, 20)
+		So(*opts.MinPoolSize, ShouldEqual, 2)
+		So(*opts.MaxConnIdleTime, ShouldEqual, 3*time.Second)
+		So(*opts.AppName, ShouldEqual, "reports")
+		So(*opts.RetryReads, ShouldBeTrue)
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.SecondaryMode)
+		So(safeFromConcerns(opts.WriteConcern, opts.ReadConcern), ShouldResemble, &Safe{W: 2, WTimeout: 100, J: true})
+	})
+	Convey("unacknowledged writes are requested explicitly", t, func() {
+		opts, err := (&DialInfo{Addrs: []string{"db1"}, Safe: Safe{RMode: "majority"}, Unacknowledged: true}).ClientOptions()
+		So(err, ShouldBeNil)
+		So(opts.WriteConcern.Acknowledged(), ShouldBeFalse)
+		So(opts.ReadConcern.GetLevel(), ShouldEqual, "majority")
+
+		opts, err = (&DialInfo{Addrs: []string{"db1"}, Safe: Safe{W: 0}}).ClientOptions()
+		So(err, ShouldBeNil)
+		So(opts.WriteConcern.Acknowledged(), ShouldBeTrue)
+	})
+	Convey("read and write timeouts default to Timeout", t, func() {
+		opts, err := (&DialInfo{Addrs: []string{"db1"}, Timeout: 3 * time.Second, WriteTimeout: time.Second}).ClientOptions()
+		So(err, ShouldBeNil)
+		So(*opts.SocketTimeout, ShouldEqual, 3*time.Second)
+
+		opts, err = (&DialInfo{Addrs: []string{"db1"}}).ClientOptions()
+		So(err, ShouldBeNil)
+		So(opts.SocketTimeout, ShouldBeNil)
+		So(opts.ServerSelectionTimeout, ShouldBeNil)
+	})
+	Convey("unsupported combinations are rejected", t, func() {
+		for _, info := range []*DialInfo{
+			{},
+			{Addrs: []string{"db1"}, Timeout: -1},
+			{Addrs: []string{"db1"}, PoolLimit: 2, MinPoolSize: 3},
+			{Addrs: []string{"db1"}, PoolTimeout: -time.Second},
+			{Addrs: []string{"db1", "db2"}, Direct: true},
+			{Addrs: []string{"db1"}, ReadPreference: &ReadPreference{Mode: Primary, TagSets: []bson.D{{{Key: "dc", Value: "east"}}}}},
+		} {
+			_, err := info.ClientOptions()
+			So(err, ShouldNotBeNil)
+			_, err = DialWithInfo(info)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolTimeout is returned when an operation waits longer than the pool
+// timeout of the session for the pool limit to allow it. See
+// Session.SetPoolLimit and Session.SetPoolTimeout.
+var ErrPoolTimeout = errors.New("could not acquire connection within pool timeout")
+
+// opSettings holds the session settings applied to the operations run
+// through the databases and collections obtained from the session.
+type opSettings struct {
+	socketTimeout   time.Duration
+	syncTimeout     time.Duration
+	poolLimit       int
+	poolTimeout     time.Duration
+	batch           int
+	prefetch        float64
+	noCursorTimeout bool
+}
+
+// timeout returns the deadline of a single operation, or zero if it's
+// unbounded.
+func (s *opSettings) timeout() time.Duration {
+	if s.socketTimeout <= 0 {
+		return 0
+	}
+	if s.syncTimeout > 0 {
+		return s.socketTimeout + s.syncTimeout
+	}
+	return s.socketTimeout
+}
+
+// begin starts an operation with ctx, bounding it with the session timeouts
+// and waiting for the pool limit to allow it. The returned function must be
+// called once the operation completes.
+func (s *opSettings) begin(ctx context.Context, limiter *opLimiter) (context.Context, func(), error) {
+	cancel := context.CancelFunc(func() {})
+	if timeout := s.timeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	if limiter == nil {
+		return ctx, cancel, nil
+	}
+	if err := limiter.acquire(ctx, s.poolLimit, s.poolTimeout); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, func() {
+		limiter.release()
+		cancel()
+	}, nil
+}
+
+// opLimiter counts the operations in progress on a client, so sessions with
+// a pool limit can wait for others to complete without reconnecting the
+// client with a different pool size.
+type opLimiter struct {
+	m        sync.Mutex
+	inUse    int
+	released chan struct{}
+}
+
+// acquire registers an operation in progress, first waiting until fewer
+// than limit operations are, if limit is positive. It waits at most
+// timeout if that is positive, and until ctx is done otherwise.
+func (l *opLimiter) acquire(ctx context.Context, limit int, timeout time.Duration) error {
+	var expired <-chan time.Time
+	for {
+		l.m.Lock()
+		if limit <= 0 || l.inUse < limit {
+			l.inUse++
+			l.m.Unlock()
+			return nil
+		}
+		if l.released == nil {
+			l.released = make(chan struct{})
+		}
+		released := l.released
+		l.m.Unlock()
+
+		if expired == nil && timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-released:
+		case <-expired:
+			return ErrPoolTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release unregisters an operation, waking up those waiting for it.
+func (l *opLimiter) release() {
+	l.m.Lock()
+	l.inUse--
+	if l.released != nil {
+		close(l.released)
+		l.released = nil
+	}
+	l.m.Unlock()
+}
+
+// SetSocketTimeout sets the amount of time to wait for an operation run
+// through the session to complete, including each round trip of an
+// iterator. A timeout of zero leaves operations unbounded but for the
+// socket timeout of the client.
+//
+// Databases and collections use the timeout the session has when they are
+// obtained.
+func (s *Session) SetSocketTimeout(d time.Duration) {
+	s.m.Lock()
+	s.settings.socketTimeout = d
+	s.m.Unlock()
+}
+
+// SetSyncTimeout sets the amount of time an operation may wait for a
+// suitable server to be available, in addition to the socket timeout.
+// It only has an effect if a socket timeout is set, as the driver selects
+// servers within the deadline of each operation. See
+// Session.SetSocketTimeout.
+func (s *Session) SetSyncTimeout(d time.Duration) {
+	s.m.Lock()
+	s.settings.syncTimeout = d
+	s.m.Unlock()
+}
+
+// SetPoolLimit sets the maximum number of operations run concurrently
+// through the client of the session before further operations wait for one
+// of them to complete. A limit of zero disables waiting. The limit counts
+// the operations of all sessions sharing the client, but only applies to
+// this session and those copied from it.
+//
+// This allows lowering the concurrency of a session without reconnecting.
+// The pool of the client itself is sized when dialing. See
+// DialInfo.PoolLimit.
+func (s *Session) SetPoolLimit(limit int) {
+	s.m.Lock()
+	s.settings.poolLimit = limit
+	s.m.Unlock()
+}
+
+// SetPoolTimeout sets the maximum time an operation waits for the pool
+// limit of the session to allow it, after which ErrPoolTimeout is returned.
+// A timeout of zero waits until the operation deadline, if any.
+func (s *Session) SetPoolTimeout(timeout time.Duration) {
+	s.m.Lock()
+	s.settings.poolTimeout = timeout
+	s.m.Unlock()
+}
+
+// SetBatch sets the default batch size used when fetching documents from
+// the database. It's possible to change this setting on a per-query basis
+// as well, using the Query.Batch method.
+//
+// The default batch size is defined by the database itself. As of this
+// writing, MongoDB will use an initial size of min(100 docs, 4MB) on the
+// first batch, and 4MB on remaining ones.
+func (s *Session) SetBatch(n int) {
+	if n == 1 {
+		// Server interprets 1 as -1 and closes the cursor (!?)
+		n = 2
+	}
+	s.m.Lock()
+	s.settings.batch = n
+	s.m.Unlock()
+}
+
+// SetPrefetch sets the default point at which the next batch of results
+// will be requested. It's possible to change this setting on a per-query
+// basis as well, using the Prefetch method of Query.
+//
+// The setting is kept for compatibility with mgo. The driver requests the
+// next batch once the current one is consumed.
+func (s *Session) SetPrefetch(p float64) {
+	s.m.Lock()
+	s.settings.prefetch = p
+	s.m.Unlock()
+}
+
+// SetCursorTimeout changes the standard timeout period that the server
+// enforces on created cursors. The only supported value right now is
+// 0, which disables the timeout. The standard server timeout is 10 minutes.
+func (s *Session) SetCursorTimeout(d time.Duration) {
+	if d != 0 {
+		panic("SetCursorTimeout: only 0 (disable timeout) supported for now")
+	}
+	s.m.Lock()
+	s.settings.noCursorTimeout = true
+	s.m.Unlock()
+}
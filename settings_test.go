@@ -0,0 +1,128 @@
+package mgo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+)
+
+func TestSession_QueryDefaults(t *testing.T) {
+	Convey("queries and pipes inherit the session defaults", t, func() {
+		session := unconnectedSession("mydb")
+		session.SetBatch(1)
+		session.SetPrefetch(0.5)
+		session.SetCursorTimeout(0)
+		So(func() { session.SetCursorTimeout(time.Minute) }, ShouldPanic)
+
+		coll := session.Copy().C("people")
+		session.SetBatch(50)
+		query := coll.Find(nil)
+		So(query.batch, ShouldEqual, 2)
+		So(query.prefetch, ShouldEqual, 0.5)
+		opts := query.toFindOptions()
+		So(*opts.BatchSize, ShouldEqual, 2)
+		So(*opts.NoCursorTimeout, ShouldBeTrue)
+		So(*query.Batch(10).toFindOptions().BatchSize, ShouldEqual, 10)
+		So(query.toFindOptions().Limit, ShouldBeNil)
+
+		pipe := session.C("people").Pipe([]bson.M{})
+		So(*pipe.toAggregateOptions().BatchSize, ShouldEqual, 50)
+		So(*pipe.Batch(5).toAggregateOptions().BatchSize, ShouldEqual, 5)
+	})
+}
+
+func TestSession_SetSocketTimeout(t *testing.T) {
+	Convey("socket and sync timeouts bound each operation", t, func() {
+		session := unconnectedSession("mydb")
+		session.SetSyncTimeout(time.Minute)
+		ctx, done, err := session.DB("").begin(nil)
+		So(err, ShouldBeNil)
+		_, ok := ctx.Deadline()
+		So(ok, ShouldBeFalse)
+		done()
+
+		session.SetSocketTimeout(time.Minute)
+		ctx, done, err = session.C("people").begin(nil)
+		So(err, ShouldBeNil)
+		deadline, ok := ctx.Deadline()
+		So(ok, ShouldBeTrue)
+		So(time.Until(deadline), ShouldBeBetween, time.Minute, 2*time.Minute+time.Second)
+		done()
+		So(ctx.Err(), ShouldEqual, context.Canceled)
+	})
+}
+
+func TestSession_SetPoolLimit(t *testing.T) {
+	Convey("operations wait for the pool limit of the session", t, func() {
+		session := unconnectedSession("mydb")
+		unlimited := session.Copy()
+		session.SetPoolLimit(1)
+		session.SetPoolTimeout(20 * time.Millisecond)
+
+		_, done, err := unlimited.DB("").begin(nil)
+		So(err, ShouldBeNil)
+		_, err = session.C("people").Count()
+		So(err, ShouldEqual, ErrPoolTimeout)
+		_, done2, err := unlimited.DB("").begin(nil)
+		So(err, ShouldBeNil)
+		done2()
+
+		session.SetPoolTimeout(0)
+		acquired := make(chan error, 1)
+		go func() {
+			_, done, err := session.DB("").begin(nil)
+			if err == nil {
+				done()
+			}
+			acquired <- err
+		}()
+		time.Sleep(5 * time.Millisecond)
+		done()
+		So(<-acquired, ShouldBeNil)
+		So(session.cluster.limiter.inUse, ShouldEqual, 0)
+	})
+}
+
+func TestDialWithInfo_PoolLimit(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		host, err := ctx.mongoC.Host(ctx)
+		So(err, ShouldBeNil)
+		port, err := ctx.mongoC.MappedPort(ctx, "27017/tcp")
+		So(err, ShouldBeNil)
+		session, err := DialWithInfo(&DialInfo{Addrs: []string{net.JoinHostPort(host, port.Port())}, PoolLimit: 1})
+		So(err, ShouldBeNil)
+		defer session.Close()
+
+		// The pool limit sizes the client pool only.
+		So(session.settings.poolLimit, ShouldEqual, 0)
+		_, done, err := session.DB("").begin(nil)
+		So(err, ShouldBeNil)
+		defer done()
+		So(session.Ping(), ShouldBeNil)
+	})
+}
+
+func TestSession_SetBatch(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		session := ctx.mongo.Copy()
+		defer session.Close()
+		session.SetBatch(2)
+		session.SetSocketTimeout(time.Minute)
+		coll := session.DB("mydb").C("batches")
+		for i := 0; i < 5; i++ {
+			So(coll.Insert(bson.M{"n": i}), ShouldBeNil)
+		}
+		var result bson.M
+		var n int
+		iter := coll.Find(nil).Iter()
+		for iter.Next(&result) {
+			n++
+		}
+		So(iter.Close(), ShouldBeNil)
+		So(n, ShouldEqual, 5)
+	})
+}
@@ -0,0 +1,230 @@
+package mgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	statsEnabled int32
+	statsMutex   sync.Mutex
+	stats        Stats
+
+	// statsGeneration is incremented whenever statistics are enabled, so
+	// clusters are only discounted from the statistics they were counted
+	// in. See countCluster.
+	statsGeneration int
+)
+
+// Stats holds the statistics gathered since they were enabled or last
+// reset, aggregated across all sessions. See SetStats.
+type Stats struct {
+	// Clusters is the number of clients held by sessions.
+	Clusters int
+
+	// MasterConns and SlaveConns count the connections established to
+	// servers accepting writes (primaries, standalone servers and mongos
+	// routers) and to the other servers.
+	MasterConns int
+	SlaveConns  int
+
+	// SentOps counts the commands sent, and ReceivedOps the replies
+	// received for them. ReceivedDocs counts the documents in the
+	// replies, taking the batch of cursor replies into account.
+	SentOps      int
+	ReceivedOps  int
+	ReceivedDocs int
+
+	// SocketsAlive is the number of pooled connections, and SocketsInUse
+	// the number of them checked out by running operations. The driver
+	// holds a connection for a single operation, so SocketRefs always
+	// equals SocketsInUse.
+	SocketsAlive int
+	SocketsInUse int
+	SocketRefs   int
+}
+
+// SetStats enables or disables the gathering of statistics. Disabling
+// them drops the statistics gathered so far.
+//
+// Statistics are gathered for the clients dialed by this package. Clients
+// passed to NewFromMongoDriver only count towards Clusters unless they were
+// created with options returned by MonitorStats.
+func SetStats(enabled bool) {
+	statsMutex.Lock()
+	if enabled {
+		if !statsOn() {
+			statsGeneration++
+		}
+		atomic.StoreInt32(&statsEnabled, 1)
+	} else {
+		atomic.StoreInt32(&statsEnabled, 0)
+		stats = Stats{}
+	}
+	statsMutex.Unlock()
+}
+
+// GetStats returns a snapshot of the statistics. See SetStats.
+func GetStats() (snapshot Stats) {
+	statsMutex.Lock()
+	snapshot = stats
+	statsMutex.Unlock()
+	return
+}
+
+// ResetStats resets the counters of operations and established connections,
+// keeping the absolute values such as the number of alive connections.
+func ResetStats() {
+	statsMutex.Lock()
+	old := stats
+	stats = Stats{}
+	// These are absolute values:
+	stats.Clusters = old.Clusters
+	stats.SocketsInUse = old.SocketsInUse
+	stats.SocketsAlive = old.SocketsAlive
+	stats.SocketRefs = old.SocketRefs
+	statsMutex.Unlock()
+}
+
+// statsOn reports whether statistics are gathered, without locking.
+func statsOn() bool {
+	return atomic.LoadInt32(&statsEnabled) != 0
+}
+
+// addStat adds delta to the statistic pointed to by field if statistics
+// are enabled.
+func addStat(field *int, delta int) {
+	if !statsOn() {
+		return
+	}
+	statsMutex.Lock()
+	if statsOn() {
+		*field += delta
+	}
+	statsMutex.Unlock()
+}
+
+// countCluster counts a new cluster in the statistics if they are enabled,
+// and returns the generation of the statistics it was counted in, or zero.
+func countCluster() int {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if !statsOn() {
+		return 0
+	}
+	stats.Clusters++
+	return statsGeneration
+}
+
+// discountCluster removes a cluster counted by countCluster in generation
+// from the statistics, unless they were dropped since.
+func discountCluster(generation int) {
+	statsMutex.Lock()
+	if statsOn() && generation != 0 && generation == statsGeneration {
+		stats.Clusters--
+	}
+	statsMutex.Unlock()
+}
+
+// MonitorStats returns a copy of opts reporting the operations and
+// connections of the client to the package statistics. Use it when creating
+// clients passed to NewFromMongoDriver. See SetStats.
+func MonitorStats(opts *options.ClientOptions) *options.ClientOptions {
+	topology := newTopologyMonitor()
+	return monitorStats(topology.monitor(opts), topology)
+}
+
+// monitorStats returns a copy of opts reporting to the package statistics,
+// telling connections to masters and slaves apart through topology. The
+// monitors already set in opts keep receiving all events.
+func monitorStats(opts *options.ClientOptions, topology *topologyMonitor) *options.ClientOptions {
+	var commandMonitor event.CommandMonitor
+	if opts.Monitor != nil {
+		commandMonitor = *opts.Monitor
+	}
+	started := commandMonitor.Started
+	commandMonitor.Started = func(ctx context.Context, e *event.CommandStartedEvent) {
+		addStat(&stats.SentOps, 1)
+		if started != nil {
+			started(ctx, e)
+		}
+	}
+	succeeded := commandMonitor.Succeeded
+	commandMonitor.Succeeded = func(ctx context.Context, e *event.CommandSucceededEvent) {
+		if statsOn() {
+			docs := replyDocs(e.Reply)
+			statsMutex.Lock()
+			if statsOn() {
+				stats.ReceivedOps++
+				stats.ReceivedDocs += docs
+			}
+			statsMutex.Unlock()
+		}
+		if succeeded != nil {
+			succeeded(ctx, e)
+		}
+	}
+	failed := commandMonitor.Failed
+	commandMonitor.Failed = func(ctx context.Context, e *event.CommandFailedEvent) {
+		addStat(&stats.ReceivedOps, 1)
+		if failed != nil {
+			failed(ctx, e)
+		}
+	}
+	var poolEvent func(*event.PoolEvent)
+	if opts.PoolMonitor != nil {
+		poolEvent = opts.PoolMonitor.Event
+	}
+	poolMonitor := &event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		if statsOn() {
+			poolStats(e, topology)
+		}
+		if poolEvent != nil {
+			poolEvent(e)
+		}
+	}}
+	return options.MergeClientOptions(opts).SetMonitor(&commandMonitor).SetPoolMonitor(poolMonitor)
+}
+
+func poolStats(e *event.PoolEvent, topology *topologyMonitor) {
+	master := e.Type == event.ConnectionCreated && topology.master(e.Address)
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if !statsOn() {
+		return
+	}
+	switch e.Type {
+	case event.ConnectionCreated:
+		if master {
+			stats.MasterConns++
+		} else {
+			stats.SlaveConns++
+		}
+		stats.SocketsAlive++
+	case event.ConnectionClosed:
+		stats.SocketsAlive--
+	case event.GetSucceeded:
+		stats.SocketsInUse++
+		stats.SocketRefs++
+	case event.ConnectionReturned:
+		stats.SocketsInUse--
+		stats.SocketRefs--
+	}
+}
+
+// replyDocs returns the number of documents in a command reply, which is
+// the size of the batch for cursor replies and one otherwise.
+func replyDocs(reply bson.Raw) int {
+	for _, key := range []string{"firstBatch", "nextBatch"} {
+		if batch, ok := reply.Lookup("cursor", key).ArrayOK(); ok {
+			docs, _ := batch.Elements()
+			return len(docs)
+		}
+	}
+	return 1
+}
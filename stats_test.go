@@ -0,0 +1,94 @@
+package mgo
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestStats(t *testing.T) {
+	Convey("statistics are gathered from the driver monitors", t, func() {
+		SetStats(true)
+		defer SetStats(false)
+
+		session := unconnectedSession("mydb")
+		scopy := session.Copy()
+		So(GetStats().Clusters, ShouldEqual, 1)
+
+		var userEvents int
+		userOpts := options.Client().SetMonitor(&event.CommandMonitor{
+			Started: func(context.Context, *event.CommandStartedEvent) { userEvents++ },
+		})
+		topology := newTopologyMonitor()
+		opts := monitorStats(topology.monitor(userOpts), topology)
+		opts.ServerMonitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+			NewDescription: description.Topology{Servers: []description.Server{
+				{Addr: "db1:27017", Kind: description.RSPrimary},
+				{Addr: "db2:27017", Kind: description.RSSecondary},
+			}},
+		})
+
+		ctx := context.Background()
+		opts.Monitor.Started(ctx, &event.CommandStartedEvent{})
+		opts.Monitor.Started(ctx, &event.CommandStartedEvent{})
+		So(userEvents, ShouldEqual, 2)
+		reply, err := driverbson.Marshal(driverbson.D{{Key: "cursor", Value: driverbson.D{
+			{Key: "firstBatch", Value: driverbson.A{driverbson.D{}, driverbson.D{}, driverbson.D{}}},
+		}}})
+		So(err, ShouldBeNil)
+		opts.Monitor.Succeeded(ctx, &event.CommandSucceededEvent{Reply: reply})
+		opts.Monitor.Failed(ctx, &event.CommandFailedEvent{})
+		for _, e := range []*event.PoolEvent{
+			{Type: event.ConnectionCreated, Address: "db1:27017"},
+			{Type: event.ConnectionCreated, Address: "db2:27017"},
+			{Type: event.ConnectionCreated, Address: "db2:27017"},
+			{Type: event.ConnectionClosed, Address: "db2:27017"},
+			{Type: event.GetSucceeded, Address: "db1:27017"},
+		} {
+			opts.PoolMonitor.Event(e)
+		}
+		So(GetStats(), ShouldResemble, Stats{
+			Clusters:     1,
+			MasterConns:  1,
+			SlaveConns:   2,
+			SentOps:      2,
+			ReceivedOps:  2,
+			ReceivedDocs: 3,
+			SocketsAlive: 2,
+			SocketsInUse: 1,
+			SocketRefs:   1,
+		})
+
+		ResetStats()
+		So(GetStats(), ShouldResemble, Stats{Clusters: 1, SocketsAlive: 2, SocketsInUse: 1, SocketRefs: 1})
+
+		scopy.Close()
+		So(GetStats().Clusters, ShouldEqual, 1)
+		session.Close()
+		So(GetStats().Clusters, ShouldEqual, 0)
+
+		SetStats(false)
+		opts.Monitor.Started(ctx, &event.CommandStartedEvent{})
+		So(GetStats(), ShouldResemble, Stats{})
+	})
+	Convey("clusters are only discounted from the statistics they were counted in", t, func() {
+		before := unconnectedSession("mydb")
+		SetStats(true)
+		defer SetStats(false)
+		counted := unconnectedSession("mydb")
+		So(GetStats().Clusters, ShouldEqual, 1)
+		before.Close()
+		So(GetStats().Clusters, ShouldEqual, 1)
+
+		SetStats(false)
+		SetStats(true)
+		So(GetStats().Clusters, ShouldEqual, 0)
+		counted.Close()
+		So(GetStats().Clusters, ShouldEqual, 0)
+	})
+}
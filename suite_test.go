@@ -2,14 +2,19 @@ package mgo
 
 import (
 	"context"
+	"errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"io/ioutil"
 	"log"
 
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"net"
 	"testing"
+	"time"
 )
 
 func GetDockerMongoC(ctx context.Context, username string, password string) (container testcontainers.Container, err error) {
@@ -51,6 +56,89 @@ func authSession(username string, password string) (session *Session, c testcont
 	}, err
 }
 
+// replSetSession works like session, with a server running as a single
+// node replica set, as change streams and transactions require.
+func replSetSession() (session *Session, c testcontainers.Container, cancel func(), err error) {
+	ctx := context.Background()
+	mongoC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "mongo",
+			ExposedPorts: []string{"27017/tcp"},
+			WaitingFor:   wait.ForListeningPort("27017/tcp"),
+			Cmd:          []string{"--replSet", "rs0", "--bind_ip_all"},
+		},
+		Started: true,
+	})
+	if err != nil {
+		return
+	}
+	cancel = func() {
+		_ = mongoC.Terminate(ctx)
+	}
+	ip, err := mongoC.Host(ctx)
+	if err != nil {
+		return
+	}
+	natPort, err := mongoC.MappedPort(ctx, "27017/tcp")
+	if err != nil {
+		return
+	}
+	// The member is known by its address within the container, so the
+	// replica set is reached with a direct connection.
+	url := "mongodb://" + net.JoinHostPort(ip, natPort.Port()) + "/test?connect=direct"
+	s, err := Dial(url)
+	if err != nil {
+		return
+	}
+	// The session is dialed again once the node is primary, as the client
+	// only learns of its support for sessions when monitoring it.
+	err = initiateReplSet(s)
+	s.Close()
+	if err != nil {
+		return
+	}
+	s, err = Dial(url)
+	return s, mongoC, cancel, err
+}
+
+// initiateReplSet initiates the single node replica set s is connected to,
+// and waits for the node to become primary.
+func initiateReplSet(s *Session) error {
+	config := bson.M{"_id": "rs0", "members": []bson.M{{"_id": 0, "host": "localhost:27017"}}}
+	if err := s.Run(bson.D{{Key: "replSetInitiate", Value: config}}, nil); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var result struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		if err := s.Run("isMaster", &result); err != nil {
+			return err
+		}
+		if result.IsMaster {
+			return nil
+		}
+	}
+	return errors.New("replica set member didn't become primary")
+}
+
+// unconnectedSession returns a session on database over a client that
+// isn't connected, for tests that don't need a server.
+func unconnectedSession(database string) *Session {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	So(err, ShouldBeNil)
+	return NewFromMongoDriver(client, database)
+}
+
+// unreachableSession returns a session on database over a connected client
+// whose server can't be reached, for tests that start driver sessions
+// without a server. Closing the session disconnects the client.
+func unreachableSession(database string) *Session {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	So(err, ShouldBeNil)
+	return newSession(newCluster(client, nil, true), database)
+}
+
 type TestContext struct {
 	context.Context
 	mongo  *Session
@@ -69,6 +157,17 @@ func MongoTest(t *testing.T, fn func(ctx *TestContext)) {
 		})
 	})
 }
+func ReplSetMongoTest(t *testing.T, fn func(ctx *TestContext)) {
+	log.SetOutput(ioutil.Discard)
+	Convey("test mongo replica set suites by docker", t, FailureHalts, func() {
+		ms, mc, cancel, err := replSetSession()
+		if cancel != nil {
+			Reset(cancel)
+		}
+		So(err, ShouldBeNil)
+		fn(&TestContext{mongo: ms, mongoC: mc, Context: context.Background()})
+	})
+}
 func AuthMongoTest(t *testing.T, fn func(ctx *TestContext)) {
 	log.SetOutput(ioutil.Discard)
 	Convey("test security mongo suites by docker", t, FailureHalts, func() {
@@ -0,0 +1,392 @@
+package mgo
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ServerAddr represents the address for establishing a connection to an
+// individual MongoDB server.
+type ServerAddr struct {
+	str string
+	tcp *net.TCPAddr
+}
+
+// newServerAddr returns the ServerAddr for addr. The TCP address is only
+// known if addr holds an IP address, as no name resolution is done.
+func newServerAddr(addr string) *ServerAddr {
+	return &ServerAddr{str: addr, tcp: parseTCPAddr(addr)}
+}
+
+// String returns the address that was provided for the server before resolution.
+func (addr *ServerAddr) String() string {
+	return addr.str
+}
+
+// TCPAddr returns the resolved TCP address for the server, or nil if the
+// address has not been resolved.
+func (addr *ServerAddr) TCPAddr() *net.TCPAddr {
+	return addr.tcp
+}
+
+// ServerKind describes the role of a server in the cluster.
+type ServerKind string
+
+const (
+	ServerUnknown    ServerKind = "unknown"
+	ServerStandalone ServerKind = "standalone"
+	ServerPrimary    ServerKind = "primary"
+	ServerSecondary  ServerKind = "secondary"
+	ServerArbiter    ServerKind = "arbiter"
+	ServerMongos     ServerKind = "mongos"
+	// ServerOther is a replica set member that is neither primary,
+	// secondary nor arbiter, such as a member being initialized or
+	// recovering.
+	ServerOther ServerKind = "other"
+)
+
+func serverKind(kind description.ServerKind) ServerKind {
+	switch kind {
+	case description.Standalone:
+		return ServerStandalone
+	case description.RSPrimary:
+		return ServerPrimary
+	case description.RSSecondary:
+		return ServerSecondary
+	case description.RSArbiter:
+		return ServerArbiter
+	case description.Mongos:
+		return ServerMongos
+	case description.RSMember, description.RSGhost:
+		return ServerOther
+	}
+	return ServerUnknown
+}
+
+// ServerState holds what is currently known about a server of the cluster,
+// as reported by the driver's monitoring.
+type ServerState struct {
+	Addr    *ServerAddr
+	Kind    ServerKind
+	SetName string
+	Tags    bson.D
+
+	// RTT is the average round trip time of the heartbeats sent to the
+	// server, and LastHeartbeat the time the last of them completed.
+	RTT           time.Duration
+	LastHeartbeat time.Time
+
+	// Err holds the error of the last failed heartbeat, if the server
+	// hasn't been reached since.
+	Err error
+
+	// Connections is the number of pooled connections to the server, of
+	// which InUse are checked out by running operations.
+	Connections int
+	InUse       int
+}
+
+// Alive reports whether the server was reachable on its last heartbeat.
+func (state *ServerState) Alive() bool {
+	return state.Kind != ServerUnknown
+}
+
+// TopologyEvent reports a server being discovered, removed, or changing its
+// kind, replica set or reachability. Previous is nil for discovered servers
+// and Current is nil for removed ones.
+type TopologyEvent struct {
+	Addr     *ServerAddr
+	Previous *ServerState
+	Current  *ServerState
+}
+
+// topologyMonitor tracks the servers of a client through the driver's
+// server and pool monitoring.
+type topologyMonitor struct {
+	m           sync.Mutex
+	servers     map[string]*ServerState
+	pools       map[string]*poolState
+	subscribers []topologySubscriber
+	lastID      int
+	pending     []TopologyEvent
+	dispatching bool
+}
+
+type poolState struct {
+	connections, inUse int
+}
+
+type topologySubscriber struct {
+	id int
+	fn func(TopologyEvent)
+}
+
+func newTopologyMonitor() *topologyMonitor {
+	return &topologyMonitor{
+		servers: make(map[string]*ServerState),
+		pools:   make(map[string]*poolState),
+	}
+}
+
+// monitor returns a copy of opts reporting to t. The monitors already set
+// in opts keep receiving all events.
+func (t *topologyMonitor) monitor(opts *options.ClientOptions) *options.ClientOptions {
+	var serverMonitor event.ServerMonitor
+	if opts.ServerMonitor != nil {
+		serverMonitor = *opts.ServerMonitor
+	}
+	topologyChanged := serverMonitor.TopologyDescriptionChanged
+	serverMonitor.TopologyDescriptionChanged = func(e *event.TopologyDescriptionChangedEvent) {
+		t.topologyChanged(e.NewDescription)
+		if topologyChanged != nil {
+			topologyChanged(e)
+		}
+	}
+	heartbeatSucceeded := serverMonitor.ServerHeartbeatSucceeded
+	serverMonitor.ServerHeartbeatSucceeded = func(e *event.ServerHeartbeatSucceededEvent) {
+		t.heartbeat(e.ConnectionID, nil)
+		if heartbeatSucceeded != nil {
+			heartbeatSucceeded(e)
+		}
+	}
+	heartbeatFailed := serverMonitor.ServerHeartbeatFailed
+	serverMonitor.ServerHeartbeatFailed = func(e *event.ServerHeartbeatFailedEvent) {
+		t.heartbeat(e.ConnectionID, e.Failure)
+		if heartbeatFailed != nil {
+			heartbeatFailed(e)
+		}
+	}
+	var poolEvent func(*event.PoolEvent)
+	if opts.PoolMonitor != nil {
+		poolEvent = opts.PoolMonitor.Event
+	}
+	poolMonitor := &event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		t.poolEvent(e)
+		if poolEvent != nil {
+			poolEvent(e)
+		}
+	}}
+	return options.MergeClientOptions(opts).SetServerMonitor(&serverMonitor).SetPoolMonitor(poolMonitor)
+}
+
+// topologyChanged replaces the known servers with those in desc, notifying
+// the subscribers of the relevant changes.
+func (t *topologyMonitor) topologyChanged(desc description.Topology) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	servers := make(map[string]*ServerState, len(desc.Servers))
+	for _, server := range desc.Servers {
+		addr := server.Addr.String()
+		state := &ServerState{
+			Kind:    serverKind(server.Kind),
+			SetName: server.SetName,
+			RTT:     server.AverageRTT,
+			Err:     server.LastError,
+		}
+		for _, tag := range server.Tags {
+			state.Tags = append(state.Tags, bson.E{Key: tag.Name, Value: tag.Value})
+		}
+		previous := t.servers[addr]
+		if previous != nil {
+			state.Addr = previous.Addr
+			state.LastHeartbeat = previous.LastHeartbeat
+		} else {
+			state.Addr = newServerAddr(addr)
+		}
+		servers[addr] = state
+		if previous == nil || previous.Kind != state.Kind || previous.SetName != state.SetName ||
+			(previous.Err == nil) != (state.Err == nil) {
+			t.publish(TopologyEvent{Addr: state.Addr, Previous: t.snapshot(previous), Current: t.snapshot(state)})
+		}
+	}
+	for addr, previous := range t.servers {
+		if _, ok := servers[addr]; !ok {
+			t.publish(TopologyEvent{Addr: previous.Addr, Previous: t.snapshot(previous)})
+		}
+	}
+	t.servers = servers
+}
+
+// heartbeat records a heartbeat completed on the monitoring connection
+// with the given id, which is the server address followed by a sequence
+// number.
+func (t *topologyMonitor) heartbeat(connectionID string, err error) {
+	addr := connectionID
+	if i := strings.LastIndex(addr, "[-"); i >= 0 {
+		addr = addr[:i]
+	}
+	t.m.Lock()
+	if state, ok := t.servers[addr]; ok {
+		state.LastHeartbeat = time.Now()
+		if err != nil {
+			state.Err = err
+		}
+	}
+	t.m.Unlock()
+}
+
+func (t *topologyMonitor) poolEvent(e *event.PoolEvent) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if e.Type == event.PoolClosedEvent {
+		delete(t.pools, e.Address)
+		return
+	}
+	pool, ok := t.pools[e.Address]
+	if !ok {
+		pool = &poolState{}
+		t.pools[e.Address] = pool
+	}
+	switch e.Type {
+	case event.ConnectionCreated:
+		pool.connections++
+	case event.ConnectionClosed:
+		pool.connections--
+	case event.GetSucceeded:
+		pool.inUse++
+	case event.ConnectionReturned:
+		pool.inUse--
+	}
+}
+
+// snapshot returns a copy of state including its pool counters, or nil if
+// state is nil. It must be called with t.m held.
+func (t *topologyMonitor) snapshot(state *ServerState) *ServerState {
+	if state == nil {
+		return nil
+	}
+	copied := *state
+	if pool, ok := t.pools[state.Addr.String()]; ok {
+		copied.Connections = pool.connections
+		copied.InUse = pool.inUse
+	}
+	return &copied
+}
+
+// master reports whether the server at addr accepts writes.
+func (t *topologyMonitor) master(addr string) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if state, ok := t.servers[addr]; ok {
+		switch state.Kind {
+		case ServerPrimary, ServerStandalone, ServerMongos:
+			return true
+		}
+	}
+	return false
+}
+
+// Servers returns the state of all known servers ordered by address.
+func (t *topologyMonitor) Servers() []ServerState {
+	t.m.Lock()
+	defer t.m.Unlock()
+	servers := make([]ServerState, 0, len(t.servers))
+	for _, state := range t.servers {
+		servers = append(servers, *t.snapshot(state))
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Addr.String() < servers[j].Addr.String()
+	})
+	return servers
+}
+
+// Subscribe registers fn to be called with every topology event, and
+// returns a function that cancels the subscription.
+func (t *topologyMonitor) Subscribe(fn func(TopologyEvent)) (cancel func()) {
+	t.m.Lock()
+	t.lastID++
+	id := t.lastID
+	t.subscribers = append(t.subscribers, topologySubscriber{id: id, fn: fn})
+	t.m.Unlock()
+	return func() {
+		t.m.Lock()
+		defer t.m.Unlock()
+		for i, sub := range t.subscribers {
+			if sub.id == id {
+				t.subscribers = append(t.subscribers[:i:i], t.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish queues e for the subscribers. It must be called with t.m held.
+// The driver reports topology changes while holding its own locks, so the
+// subscribers are called from a separate goroutine, in order.
+func (t *topologyMonitor) publish(e TopologyEvent) {
+	if len(t.subscribers) == 0 {
+		return
+	}
+	t.pending = append(t.pending, e)
+	if !t.dispatching {
+		t.dispatching = true
+		go t.dispatch()
+	}
+}
+
+func (t *topologyMonitor) dispatch() {
+	t.m.Lock()
+	for len(t.pending) > 0 {
+		e := t.pending[0]
+		t.pending = t.pending[1:]
+		subscribers := t.subscribers
+		t.m.Unlock()
+		for _, sub := range subscribers {
+			sub.fn(e)
+		}
+		t.m.Lock()
+	}
+	t.pending = nil
+	t.dispatching = false
+	t.m.Unlock()
+}
+
+// LiveServers returns a list of server addresses which are currently known
+// to be alive.
+//
+// Servers are only tracked for sessions established by this package, so
+// sessions created with NewFromMongoDriver report none.
+func (s *Session) LiveServers() (addrs []string) {
+	for _, state := range s.Servers() {
+		if state.Alive() {
+			addrs = append(addrs, state.Addr.String())
+		}
+	}
+	return addrs
+}
+
+// Servers returns the state of every server known to the session's client,
+// ordered by address. See Session.LiveServers.
+func (s *Session) Servers() []ServerState {
+	s.m.RLock()
+	topology := s.mongoCluster().topology
+	s.m.RUnlock()
+	if topology == nil {
+		return nil
+	}
+	return topology.Servers()
+}
+
+// SubscribeTopology registers fn to be called whenever a server of the
+// session's client is discovered, removed, or changes its kind, replica set
+// or reachability. The events are delivered in order from a goroutine of
+// their own. The returned function cancels the subscription.
+//
+// Sessions created with NewFromMongoDriver report no events.
+func (s *Session) SubscribeTopology(fn func(TopologyEvent)) (cancel func()) {
+	s.m.RLock()
+	topology := s.mongoCluster().topology
+	s.m.RUnlock()
+	if topology == nil {
+		return func() {}
+	}
+	return topology.Subscribe(fn)
+}
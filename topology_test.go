@@ -0,0 +1,105 @@
+package mgo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+func TestServerAddr(t *testing.T) {
+	Convey("ServerAddr exposes the address and its resolution", t, func() {
+		addr := newServerAddr("127.0.0.1:27017")
+		So(addr.String(), ShouldEqual, "127.0.0.1:27017")
+		So(addr.TCPAddr().Port, ShouldEqual, 27017)
+		So(newServerAddr("db1:27017").TCPAddr(), ShouldBeNil)
+	})
+}
+
+func TestSession_LiveServers(t *testing.T) {
+	Convey("servers are tracked through the driver monitors", t, func() {
+		session := unconnectedSession("mydb")
+		So(session.LiveServers(), ShouldBeEmpty)
+		So(session.SubscribeTopology(func(TopologyEvent) {}), ShouldNotBeNil)
+
+		var userEvents int
+		userOpts := options.Client().SetServerMonitor(&event.ServerMonitor{
+			TopologyDescriptionChanged: func(*event.TopologyDescriptionChangedEvent) { userEvents++ },
+		})
+		monitor := newTopologyMonitor()
+		opts := monitor.monitor(userOpts)
+		session.cluster.topology = monitor
+
+		events := make(chan TopologyEvent, 10)
+		cancel := session.SubscribeTopology(func(e TopologyEvent) { events <- e })
+
+		opts.ServerMonitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+			NewDescription: description.Topology{Servers: []description.Server{
+				{Addr: "db2:27017", Kind: description.RSSecondary, SetName: "rs0", AverageRTT: time.Millisecond,
+					Tags: tag.Set{{Name: "dc", Value: "east"}}},
+				{Addr: "db1:27017", Kind: description.RSPrimary, SetName: "rs0"},
+				{Addr: "db3:27017", LastError: errors.New("connection refused")},
+			}},
+		})
+		So(userEvents, ShouldEqual, 1)
+		opts.ServerMonitor.ServerHeartbeatSucceeded(&event.ServerHeartbeatSucceededEvent{ConnectionID: "db1:27017[-3]"})
+		opts.PoolMonitor.Event(&event.PoolEvent{Type: event.ConnectionCreated, Address: "db1:27017"})
+		opts.PoolMonitor.Event(&event.PoolEvent{Type: event.GetSucceeded, Address: "db1:27017"})
+
+		So(session.LiveServers(), ShouldResemble, []string{"db1:27017", "db2:27017"})
+		servers := session.Servers()
+		So(servers, ShouldHaveLength, 3)
+		So(servers[0].Kind, ShouldEqual, ServerPrimary)
+		So(servers[0].LastHeartbeat.IsZero(), ShouldBeFalse)
+		So(servers[0].Connections, ShouldEqual, 1)
+		So(servers[0].InUse, ShouldEqual, 1)
+		So(servers[1].Kind, ShouldEqual, ServerSecondary)
+		So(servers[1].RTT, ShouldEqual, time.Millisecond)
+		So(servers[1].Tags, ShouldResemble, bson.D{{Key: "dc", Value: "east"}})
+		So(servers[2].Alive(), ShouldBeFalse)
+		So(servers[2].Err, ShouldNotBeNil)
+		for i := 0; i < 3; i++ {
+			e := <-events
+			So(e.Previous, ShouldBeNil)
+			So(e.Current, ShouldNotBeNil)
+		}
+
+		opts.ServerMonitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+			NewDescription: description.Topology{Servers: []description.Server{
+				{Addr: "db1:27017", Kind: description.RSSecondary, SetName: "rs0"},
+				{Addr: "db2:27017", Kind: description.RSSecondary, SetName: "rs0", AverageRTT: 2 * time.Millisecond},
+			}},
+		})
+		changed := map[string]TopologyEvent{}
+		for i := 0; i < 2; i++ {
+			e := <-events
+			changed[e.Addr.String()] = e
+		}
+		So(changed["db1:27017"].Previous.Kind, ShouldEqual, ServerPrimary)
+		So(changed["db1:27017"].Current.Kind, ShouldEqual, ServerSecondary)
+		So(changed["db3:27017"].Current, ShouldBeNil)
+
+		cancel()
+		opts.ServerMonitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{})
+		So(session.LiveServers(), ShouldBeEmpty)
+		select {
+		case e := <-events:
+			So(e, ShouldBeNil)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestSession_LiveServersDialed(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		So(ctx.mongo.Ping(), ShouldBeNil)
+		So(ctx.mongo.LiveServers(), ShouldNotBeEmpty)
+		So(ctx.mongo.Servers()[0].Addr.String(), ShouldNotBeEmpty)
+	})
+}
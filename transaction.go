@@ -0,0 +1,166 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// DefaultTransactionTimeout is the time Session.RunTransaction keeps
+// retrying a transaction if TransactionOptions.Timeout is unset.
+const DefaultTransactionTimeout = 120 * time.Second
+
+// The error labels the server attaches to errors that may be retried.
+const (
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// TransactionOptions holds the settings of a transaction run with
+// Session.RunTransaction.
+type TransactionOptions struct {
+	// Safe defines the write concern used to commit the transaction and,
+	// through RMode, its read concern. Defaults to the safety mode of the
+	// session, or to a majority write concern if that is unacknowledged,
+	// as transactions can't be committed without acknowledgement.
+	Safe *Safe
+
+	// ReadPreference defines the servers read from within the transaction.
+	// Defaults to the primary, which is the only mode the server supports
+	// for transactions that read.
+	ReadPreference *ReadPreference
+
+	// Timeout is the time during which the transaction is retried on
+	// transient errors. Defaults to DefaultTransactionTimeout.
+	Timeout time.Duration
+}
+
+// transactionOptions returns the driver options for a transaction run by
+// session with opts, and the timeout for retrying it.
+func (opts *TransactionOptions) transactionOptions(safe *Safe) (*options.TransactionOptions, time.Duration, error) {
+	var o TransactionOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Safe == nil {
+		o.Safe = safe
+	}
+	txnOpts := options.Transaction()
+	if o.Safe != nil {
+		txnOpts.SetWriteConcern(o.Safe.writeConcern())
+	} else {
+		txnOpts.SetWriteConcern((&Safe{WMode: "majority"}).writeConcern())
+	}
+	if rc := o.Safe.readConcern(); rc != nil {
+		txnOpts.SetReadConcern(rc)
+	}
+	if o.ReadPreference != nil {
+		rp, err := o.ReadPreference.readPref()
+		if err != nil {
+			return nil, 0, err
+		}
+		txnOpts.SetReadPreference(rp)
+	} else {
+		txnOpts.SetReadPreference(readpref.Primary())
+	}
+	if o.Timeout < 0 {
+		return nil, 0, errors.New("RunTransaction: negative TransactionOptions.Timeout")
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultTransactionTimeout
+	}
+	return txnOpts, o.Timeout, nil
+}
+
+// RunTransaction runs fn within a multi-document transaction, committing it
+// once fn returns nil and aborting it otherwise. The databases, collections,
+// queries, pipes and bulks obtained from the tx session passed to fn run
+// within the transaction, while those of s don't. The tx session must not be
+// used concurrently nor after fn returns.
+//
+// If fn or the commit fails with an error labeled as a
+// TransientTransactionError, the whole transaction is retried, so fn must
+// be safe to run multiple times. A commit failing with an
+// UnknownTransactionCommitResult error is retried alone. Retrying stops once
+// the timeout in opts is exceeded, returning the last error.
+//
+// Sessions made causally consistent with SetCausalConsistency run the
+// transaction within their own driver session, so it observes their
+// preceding operations and their following operations observe it.
+//
+// Transactions require MongoDB 4.0 or later running as a replica set, or
+// 4.2 or later for sharded clusters.
+func (s *Session) RunTransaction(fn func(tx *Session) error, opts *TransactionOptions) error {
+	s.m.RLock()
+	if s.driverSession != nil && !s.causal {
+		s.m.RUnlock()
+		return errors.New("RunTransaction: nested transactions are not supported")
+	}
+	tx := shallowCopy(s)
+	s.m.RUnlock()
+	if tx.causalErr != nil {
+		return tx.causalErr
+	}
+	txnOpts, timeout, err := opts.transactionOptions(tx.safe)
+	if err != nil {
+		return err
+	}
+	ctx := contextOrBackground(tx.ctx)
+	sess := tx.driverSession
+	if sess == nil {
+		if sess, err = tx.cluster.client.StartSession(); err != nil {
+			return err
+		}
+		defer sess.EndSession(context.Background())
+		tx.driverSession = sess
+	}
+	// The causally consistent session of s, if any, runs the transaction,
+	// but tx doesn't own it.
+	tx.causal = false
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := sess.StartTransaction(txnOpts); err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			// Abort regardless of ctx, which may be what made fn fail.
+			_ = sess.AbortTransaction(context.Background())
+			if time.Now().Before(deadline) && hasErrorLabel(err, transientTransactionError) {
+				continue
+			}
+			return err
+		}
+		for {
+			err = sess.CommitTransaction(ctx)
+			if err == nil || ctx.Err() != nil || !time.Now().Before(deadline) {
+				return err
+			}
+			if hasErrorLabel(err, unknownTransactionCommitResult) && !isMaxTimeMSExpired(err) {
+				continue
+			}
+			break
+		}
+		if !hasErrorLabel(err, transientTransactionError) {
+			return err
+		}
+	}
+}
+
+// hasErrorLabel reports whether err is, or wraps, a server error labeled
+// with label.
+func hasErrorLabel(err error, label string) bool {
+	var serr mongo.ServerError
+	return errors.As(err, &serr) && serr.HasErrorLabel(label)
+}
+
+// isMaxTimeMSExpired reports whether err reports the commit exceeding its
+// maximum time, in which case retrying it is pointless.
+func isMaxTimeMSExpired(err error) bool {
+	cerr, ok := err.(mongo.CommandError)
+	return ok && cerr.IsMaxTimeMSExpiredError()
+}
@@ -0,0 +1,95 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestTransactionOptions(t *testing.T) {
+	Convey("transaction options default to the session settings", t, func() {
+		opts, timeout, err := (*TransactionOptions)(nil).transactionOptions(nil)
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, DefaultTransactionTimeout)
+		So(opts.WriteConcern.GetW(), ShouldEqual, "majority")
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.PrimaryMode)
+
+		opts, timeout, err = (&TransactionOptions{
+			Safe:           &Safe{W: 2, RMode: "snapshot"},
+			ReadPreference: &ReadPreference{Mode: Nearest},
+			Timeout:        time.Second,
+		}).transactionOptions(&Safe{})
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, time.Second)
+		So(opts.WriteConcern.GetW(), ShouldEqual, 2)
+		So(opts.ReadConcern, ShouldResemble, readconcern.Snapshot())
+		So(opts.ReadPreference.Mode(), ShouldEqual, readpref.NearestMode)
+
+		_, _, err = (&TransactionOptions{Timeout: -1}).transactionOptions(&Safe{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSession_RunTransaction(t *testing.T) {
+	Convey("operations of the tx session run within the driver session", t, func() {
+		session := unreachableSession("mydb")
+		defer session.Close()
+
+		var runs int
+		stop := errors.New("stop")
+		err := session.RunTransaction(func(tx *Session) error {
+			runs++
+			So(mongo.SessionFromContext(tx.C("orders").opContext(nil)), ShouldNotBeNil)
+			So(mongo.SessionFromContext(tx.DB("shop").opContext(context.Background())), ShouldNotBeNil)
+			So(mongo.SessionFromContext(session.C("orders").opContext(nil)), ShouldBeNil)
+			So(tx.RunTransaction(func(*Session) error { return nil }, nil), ShouldNotBeNil)
+			if runs == 1 {
+				return mongo.CommandError{Message: "write conflict", Labels: []string{"TransientTransactionError"}}
+			}
+			return stop
+		}, nil)
+		So(err, ShouldEqual, stop)
+		So(runs, ShouldEqual, 2)
+
+		err = session.RunTransaction(func(tx *Session) error { return nil }, nil)
+		So(err, ShouldBeNil)
+
+		// Labeled errors are found when wrapped, as by failed bulks.
+		transient := mongo.CommandError{Message: "write conflict", Labels: []string{"TransientTransactionError"}}
+		runs = 0
+		err = session.RunTransaction(func(tx *Session) error {
+			runs++
+			switch runs {
+			case 1:
+				return fmt.Errorf("inserting orders: %w", transient)
+			case 2:
+				return &BulkError{ecases: []BulkErrorCase{}, err: transient}
+			}
+			return stop
+		}, nil)
+		So(err, ShouldEqual, stop)
+		So(runs, ShouldEqual, 3)
+	})
+}
+
+func TestBulkError_Unwrap(t *testing.T) {
+	Convey("bulk errors keep the driver error", t, func() {
+		cerr := mongo.CommandError{Code: 11000, Message: "duplicate key", Labels: []string{"TransientTransactionError"}}
+		err := error(&BulkError{ecases: []BulkErrorCase{}, err: cerr})
+		So(err.Error(), ShouldEqual, "duplicate key")
+		var unwrapped mongo.CommandError
+		So(errors.As(err, &unwrapped), ShouldBeTrue)
+		So(unwrapped.Code, ShouldEqual, 11000)
+		So(hasErrorLabel(err, transientTransactionError), ShouldBeTrue)
+		So(hasErrorLabel(err, unknownTransactionCommitResult), ShouldBeFalse)
+		So(IsDup(err), ShouldBeTrue)
+		So(IsDup(&BulkError{err: errors.New("connection reset")}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,142 @@
+package txn
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yaziming/mgo/bson"
+)
+
+// logLogger is the interface of the loggers accepted by SetLogger. It is
+// satisfied by *log.Logger.
+type logLogger interface {
+	Output(calldepth int, s string) error
+}
+
+var (
+	globalMutex  sync.RWMutex
+	globalLogger logLogger
+	globalDebug  bool
+)
+
+// SetLogger specifies the *log.Logger where logged messages should be
+// sent to.
+func SetLogger(logger logLogger) {
+	globalMutex.Lock()
+	globalLogger = logger
+	globalMutex.Unlock()
+}
+
+// SetDebug enables or disables debugging.
+func SetDebug(debug bool) {
+	globalMutex.Lock()
+	globalDebug = debug
+	globalMutex.Unlock()
+}
+
+// debugEnabled reports whether debug messages are delivered.
+func debugEnabled() bool {
+	globalMutex.RLock()
+	defer globalMutex.RUnlock()
+	return globalDebug && globalLogger != nil
+}
+
+var debugId uint32
+
+func debugPrefix() string {
+	d := atomic.AddUint32(&debugId, 1) - 1
+	s := make([]byte, 0, 10)
+	for i := uint(0); i < 8; i++ {
+		s = append(s, "abcdefghijklmnop"[(d>>(4*i))&0xf])
+		if d>>(4*(i+1)) == 0 {
+			break
+		}
+	}
+	s = append(s, ')', ' ')
+	return string(s)
+}
+
+func logf(format string, args ...interface{}) {
+	globalMutex.RLock()
+	logger := globalLogger
+	globalMutex.RUnlock()
+	if logger != nil {
+		_ = logger.Output(2, fmt.Sprintf(format, argsForLog(args)...))
+	}
+}
+
+func debugf(format string, args ...interface{}) {
+	globalMutex.RLock()
+	logger, debug := globalLogger, globalDebug
+	globalMutex.RUnlock()
+	if debug && logger != nil {
+		_ = logger.Output(2, fmt.Sprintf(format, argsForLog(args)...))
+	}
+}
+
+func argsForLog(args []interface{}) []interface{} {
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case bson.ObjectId:
+			args[i] = v.Hex()
+		case []bson.ObjectId:
+			lst := make([]string, len(v))
+			for j, id := range v {
+				lst[j] = id.Hex()
+			}
+			args[i] = lst
+		case [][]bson.ObjectId:
+			lst := make([][]string, len(v))
+			for j, ids := range v {
+				lst[j] = argsForLog([]interface{}{ids})[0].([]string)
+			}
+			args[i] = lst
+		case map[bson.ObjectId][]bson.ObjectId:
+			buf := &bytes.Buffer{}
+			var ids []string
+			for id := range v {
+				ids = append(ids, id.Hex())
+			}
+			sort.Strings(ids)
+			for j, id := range ids {
+				if j > 0 {
+					buf.WriteByte(' ')
+				}
+				fmt.Fprintf(buf, "%s: %v", id, argsForLog([]interface{}{v[bson.ObjectIdHex(id)]})[0])
+			}
+			args[i] = buf.String()
+		case map[docKey][]token:
+			buf := &bytes.Buffer{}
+			var dkeys docKeys
+			for dkey := range v {
+				dkeys = append(dkeys, dkey)
+			}
+			sort.Sort(dkeys)
+			for j, dkey := range dkeys {
+				if j > 0 {
+					buf.WriteByte(' ')
+				}
+				fmt.Fprintf(buf, "%v: %v", dkey, v[dkey])
+			}
+			args[i] = buf.String()
+		case map[docKey][]int64:
+			buf := &bytes.Buffer{}
+			var dkeys docKeys
+			for dkey := range v {
+				dkeys = append(dkeys, dkey)
+			}
+			sort.Sort(dkeys)
+			for j, dkey := range dkeys {
+				if j > 0 {
+					buf.WriteByte(' ')
+				}
+				fmt.Fprintf(buf, "%v: %v", dkey, v[dkey])
+			}
+			args[i] = buf.String()
+		}
+	}
+	return args
+}
@@ -0,0 +1,236 @@
+package txn
+
+import (
+	"errors"
+
+	"github.com/yaziming/mgo"
+	"github.com/yaziming/mgo/bson"
+)
+
+// errPending is returned within a server transaction when one of the
+// documents it touches is part of a transaction that isn't done yet.
+var errPending = errors.New("documents have pending transactions")
+
+// errAssert is returned within a server transaction when one of its
+// assertions fails.
+var errAssert = errors.New("transaction assertion failed")
+
+// SetServerTransactions sets whether Run applies transactions within the
+// multi-document transactions of the server, which requires MongoDB 4.0
+// or later running as a replica set, or 4.2 or later for sharded clusters.
+// With servers older than 4.4, the collections touched, the transaction
+// collection and its stash must also exist beforehand.
+//
+// Transactions applied this way leave txn-queue, txn-revno and the stash
+// as the client side protocol would, and are stored in the transaction
+// collection as applied or aborted, so runners with and without server
+// transactions may run side by side, including those of the original mgo
+// package. If any of the documents touched is part of a transaction that
+// isn't done yet, Run falls back to the client side protocol, which
+// completes the pending transaction first. Resume and ResumeAll always use
+// the client side protocol.
+func (r *Runner) SetServerTransactions(enabled bool) {
+	r.server = enabled
+}
+
+// runServer applies t within a server transaction.
+func (r *Runner) runServer(t *transaction) error {
+	err := r.tc.Database().Session().RunTransaction(func(tx *mgo.Session) error {
+		return r.applyServer(tx, t)
+	}, nil)
+	switch err {
+	case errPending:
+		debugf("Falling back to the client side protocol for %s: documents have pending transactions", t)
+		return r.run(t)
+	case errAssert:
+		aborted := *t
+		aborted.State = taborted
+		if err := r.tc.Insert(&aborted); err != nil {
+			return err
+		}
+		return ErrAborted
+	}
+	return err
+}
+
+// applyServer applies t with tx, which must be running a server transaction.
+func (r *Runner) applyServer(tx *mgo.Session, t *transaction) error {
+	db := tx.DB(r.tc.Database().Name())
+	tc := db.C(r.tc.Name())
+	sc := db.C(r.sc.Name())
+	tt := tokenFor(t)
+	debugf("Applying %s within a server transaction", tt)
+
+	// Documents are prepared as with the client side protocol, so runners
+	// preparing them concurrently conflict with the server transaction.
+	dkeys := t.docKeys()
+	revno := make(map[docKey]int64, len(dkeys))
+	stale := make(map[docKey][]token, len(dkeys))
+	for _, dkey := range dkeys {
+		change := mgo.Change{
+			Update:    bson.M{"$addToSet": bson.M{"txn-queue": tt}},
+			ReturnNew: true,
+		}
+		var info txnInfo
+		_, err := db.C(dkey.C).Find(idQuery(dkey.Id)).Select(txnFields).Apply(change, &info)
+		if err == mgo.ErrNotFound {
+			change.Upsert = true
+			info = txnInfo{}
+			if _, err = sc.Find(idQuery(dkey)).Apply(change, &info); err == nil && info.Revno == 0 {
+				// Missing revno in the stash means -1.
+				info.Revno = -1
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Insert.IsZero() || !info.Remove.IsZero() {
+			return errPending
+		}
+		for _, dtt := range info.Queue {
+			if dtt == tt {
+				continue
+			}
+			done, err := tokenDone(tc, dtt)
+			if err != nil {
+				return err
+			}
+			if !done {
+				return errPending
+			}
+			stale[dkey] = append(stale[dkey], dtt)
+		}
+		revno[dkey] = info.Revno
+	}
+	revnos := assembledRevnos(t.Ops, revno)
+
+	// Assertions are tested on the documents as they were before the
+	// transaction, except for DocMissing which considers earlier inserts,
+	// as the client side protocol does.
+	for i, op := range t.Ops {
+		if op.Assert == nil {
+			continue
+		}
+		if op.Assert == DocMissing {
+			if revnos[i] >= 0 {
+				return errAssert
+			}
+			continue
+		}
+		qdoc := idQuery(op.Id)
+		if op.Assert != DocExists {
+			qdoc = append(qdoc, bson.E{Key: "$or", Value: bson.A{op.Assert}})
+		}
+		if err := db.C(op.C).Find(qdoc).Select(bson.M{"_id": 1}).One(nil); err == mgo.ErrNotFound {
+			return errAssert
+		} else if err != nil {
+			return err
+		}
+	}
+
+	logDoc := bson.D{{Key: "_id", Value: t.Id}}
+	for i := range t.Ops {
+		op := &t.Ops[i]
+		dkey := op.docKey()
+		c := db.C(op.C)
+		logRevno := revnos[i]
+		switch {
+		case op.Update != nil && revnos[i] >= 0:
+			logRevno = revnos[i] + 1
+			d, err := objToDoc(op.Update)
+			if err != nil {
+				return err
+			}
+			if d, err = addToDoc(d, "$set", bson.D{{Key: "txn-revno", Value: logRevno}}); err != nil {
+				return err
+			}
+			if err = c.Update(idQuery(op.Id), d); err != nil {
+				return err
+			}
+		case op.Remove && revnos[i] >= 0:
+			logRevno = -revnos[i] - 1
+			var info txnInfo
+			if err := c.Find(idQuery(op.Id)).Select(txnFields).One(&info); err != nil {
+				return err
+			}
+			if err := c.Remove(idQuery(op.Id)); err != nil {
+				return err
+			}
+			set := bson.M{"txn-queue": info.Queue, "txn-revno": logRevno}
+			if _, err := sc.Upsert(idQuery(dkey), bson.M{"$set": set}); err != nil {
+				return err
+			}
+		case op.Insert != nil && revnos[i] < 0:
+			logRevno = -revnos[i] + 1
+			var info txnInfo
+			if err := sc.Find(idQuery(dkey)).One(&info); err != nil {
+				return err
+			}
+			if err := sc.Remove(idQuery(dkey)); err != nil {
+				return err
+			}
+			d, err := objToDoc(op.Insert)
+			if err != nil {
+				return err
+			}
+			d = setInDoc(d, bson.D{{Key: "_id", Value: op.Id}, {Key: "txn-revno", Value: logRevno}, {Key: "txn-queue", Value: info.Queue}})
+			if err = c.Insert(d); err != nil {
+				return err
+			}
+		}
+		if r.lc != nil && op.isChange() {
+			logDoc = addToLog(logDoc, op, logRevno)
+		}
+	}
+
+	// Pull tt and the leftovers of done transactions from the queues,
+	// wherever the documents ended up.
+	for _, dkey := range dkeys {
+		udoc := bson.M{"$pullAll": bson.M{"txn-queue": append(stale[dkey], tt)}}
+		if revno[dkey] >= 0 {
+			if err := db.C(dkey.C).Update(idQuery(dkey.Id), udoc); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := sc.Update(idQuery(dkey), udoc); err != nil {
+			return err
+		}
+		// A stash without a revno only tracks the queue of a missing
+		// document, which is now empty.
+		if err := sc.Remove(bson.M{"_id": dkey, "txn-queue": bson.A{}, "txn-revno": bson.M{"$exists": false}}); err != nil {
+			return err
+		}
+	}
+
+	applied := *t
+	applied.State = tapplied
+	applied.Nonce = tt.nonce()
+	applied.Revnos = revnos
+	if err := tc.Insert(&applied); err != nil {
+		return err
+	}
+	if r.lc != nil {
+		lc := tx.DB(r.lc.Database().Name()).C(r.lc.Name())
+		if err := lc.Insert(logDoc); err != nil {
+			return err
+		}
+	}
+	debugf("Applied %s within a server transaction", tt)
+	return nil
+}
+
+// tokenDone reports whether the transaction of tt is done, or whether tt
+// is a leftover of a runner that lost the race to prepare it. Either way,
+// tt may be pulled from the queues it is in.
+func tokenDone(tc *mgo.Collection, tt token) (bool, error) {
+	var t transaction
+	err := tc.FindId(tt.id()).Select(bson.M{"s": 1, "n": 1}).One(&t)
+	if err == mgo.ErrNotFound {
+		// Left for the client side protocol to report.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return t.done() || t.State != tpreparing && t.Nonce != tt.nonce(), nil
+}
@@ -0,0 +1,124 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/yaziming/mgo"
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unconnectedSession returns a session on database over a client that
+// isn't connected, for tests that don't need a server.
+func unconnectedSession(database string) *mgo.Session {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	So(err, ShouldBeNil)
+	return mgo.NewFromMongoDriver(client, database)
+}
+
+// session starts a server in docker and returns a session on its test
+// database. If replSet is set, the server runs as a single node replica
+// set, which server transactions require.
+func session(replSet bool) (session *mgo.Session, c testcontainers.Container, cancel func(), err error) {
+	ctx := context.Background()
+	req := testcontainers.ContainerRequest{
+		Image:        "mongo",
+		ExposedPorts: []string{"27017/tcp"},
+		WaitingFor:   wait.ForListeningPort("27017/tcp"),
+	}
+	if replSet {
+		req.Cmd = []string{"--replSet", "rs0", "--bind_ip_all"}
+	}
+	mongoC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true})
+	if err != nil {
+		return
+	}
+	cancel = func() {
+		_ = mongoC.Terminate(ctx)
+	}
+	ip, err := mongoC.Host(ctx)
+	if err != nil {
+		return
+	}
+	natPort, err := mongoC.MappedPort(ctx, "27017/tcp")
+	if err != nil {
+		return
+	}
+	// The member is known by its address within the container, so the
+	// replica set is reached with a direct connection.
+	url := "mongodb://" + net.JoinHostPort(ip, natPort.Port()) + "/test?connect=direct"
+	s, err := mgo.Dial(url)
+	if err != nil || !replSet {
+		return s, mongoC, cancel, err
+	}
+	// The session is dialed again once the node is primary, as the client
+	// only learns of its support for sessions when monitoring it.
+	err = initiate(s)
+	s.Close()
+	if err != nil {
+		return nil, mongoC, cancel, err
+	}
+	s, err = mgo.Dial(url)
+	return s, mongoC, cancel, err
+}
+
+// initiate initiates the single node replica set s is connected to, and
+// waits for the node to become primary.
+func initiate(s *mgo.Session) error {
+	config := bson.M{"_id": "rs0", "members": []bson.M{{"_id": 0, "host": "localhost:27017"}}}
+	if err := s.Run(bson.D{{Key: "replSetInitiate", Value: config}}, nil); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var result struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		if err := s.Run("isMaster", &result); err != nil {
+			return err
+		}
+		if result.IsMaster {
+			return nil
+		}
+	}
+	return errors.New("replica set member didn't become primary")
+}
+
+type TestContext struct {
+	context.Context
+	mongo  *mgo.Session
+	mongoC testcontainers.Container
+}
+
+func MongoTest(t *testing.T, fn func(ctx *TestContext)) {
+	log.SetOutput(ioutil.Discard)
+	Convey("test mongo suites by docker", t, FailureHalts, func() {
+		ms, mc, cancel, err := session(false)
+		if cancel != nil {
+			Reset(cancel)
+		}
+		So(err, ShouldBeNil)
+		fn(&TestContext{mongo: ms, mongoC: mc, Context: context.Background()})
+	})
+}
+
+func ReplSetMongoTest(t *testing.T, fn func(ctx *TestContext)) {
+	log.SetOutput(ioutil.Discard)
+	Convey("test mongo replica set suites by docker", t, FailureHalts, func() {
+		ms, mc, cancel, err := session(true)
+		if cancel != nil {
+			Reset(cancel)
+		}
+		So(err, ShouldBeNil)
+		fn(&TestContext{mongo: ms, mongoC: mc, Context: context.Background()})
+	})
+}
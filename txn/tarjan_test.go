@@ -0,0 +1,34 @@
+package txn
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+)
+
+func TestTarjanSort(t *testing.T) {
+	Convey("transactions are sorted with cycles grouped together", t, func() {
+		var ids []bson.ObjectId
+		for i := 0; i < 8; i++ {
+			ids = append(ids, bson.ObjectIdHex(fmt.Sprintf("5f000000000000000000000%d", i)))
+		}
+		successors := map[bson.ObjectId][]bson.ObjectId{
+			ids[0]: {ids[1]},
+			ids[1]: {ids[2], ids[3]},
+			ids[2]: {ids[1], ids[4]},
+			ids[3]: {},
+			ids[4]: {ids[5]},
+			ids[5]: {ids[6], ids[7]},
+			ids[6]: {ids[4]},
+			ids[7]: {ids[4]},
+		}
+		So(tarjanSort(successors), ShouldResemble, [][]bson.ObjectId{
+			{ids[4], ids[5], ids[6], ids[7]},
+			{ids[3]},
+			{ids[1], ids[2]},
+			{ids[0]},
+		})
+	})
+}
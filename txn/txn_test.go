@@ -0,0 +1,651 @@
+package txn
+
+import (
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo"
+	"github.com/yaziming/mgo/bson"
+)
+
+func TestRunner_RunInvalidOps(t *testing.T) {
+	Convey("invalid operations are rejected before touching the database", t, func() {
+		runner := NewRunner(unconnectedSession("mydb").DB("mydb").C("txns"))
+		So(runner.sc.FullName(), ShouldEqual, "mydb.txns.stash")
+
+		for _, ops := range [][]Op{
+			{{Id: 1, Insert: bson.M{}}},
+			{{C: "accounts", Insert: bson.M{}}},
+			{{C: "accounts", Id: 1, Insert: bson.M{}, Remove: true}},
+			{{C: "accounts", Id: 1}},
+			{{C: "accounts", Id: 1, Assert: DocExists, Insert: bson.M{}}},
+		} {
+			So(runner.Run(ops, bson.NilObjectID, nil), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestDocKeys(t *testing.T) {
+	Convey("document keys are deduplicated and sorted by collection and id", t, func() {
+		type structId struct {
+			A int
+			B string `bson:"x"`
+		}
+		oid1 := bson.ObjectIdHex("5f0000000000000000000001")
+		oid2 := bson.ObjectIdHex("5f0000000000000000000002")
+		tx := &transaction{Ops: []Op{
+			{C: "b", Id: 1},
+			{C: "a", Id: structId{2, "b"}},
+			{C: "a", Id: true},
+			{C: "a", Id: 1.5},
+			{C: "a", Id: int32(7)},
+			{C: "a", Id: 7},
+			{C: "a", Id: oid2},
+			{C: "a", Id: "z"},
+			{C: "a", Id: oid1},
+			{C: "a", Id: structId{2, "a"}},
+			{C: "a", Id: uint8(3)},
+		}}
+		dkeys := tx.docKeys()
+		So(dkeys, ShouldHaveLength, 10)
+		So(sort.IsSorted(dkeys), ShouldBeTrue)
+		So(dkeys[0].Id, ShouldEqual, oid1)
+		So(dkeys[1].Id, ShouldEqual, oid2)
+		So(dkeys[2].Id, ShouldEqual, "z")
+		So(dkeys[3].Id, ShouldEqual, uint8(3))
+		So(dkeys[4].Id, ShouldEqual, 7)
+		So(dkeys[5].Id, ShouldEqual, 1.5)
+		So(dkeys[6].Id, ShouldEqual, true)
+		So(dkeys[7].Id, ShouldResemble, structId{2, "a"})
+		So(dkeys[8].Id, ShouldResemble, structId{2, "b"})
+		So(dkeys[9], ShouldResemble, docKey{"b", 1})
+	})
+}
+
+func TestAssembledRevnos(t *testing.T) {
+	Convey("revisions follow the inserts, updates and removes of a transaction", t, func() {
+		ops := []Op{
+			{C: "a", Id: 1, Insert: bson.M{}},
+			{C: "a", Id: 1, Update: bson.M{}},
+			{C: "a", Id: 1, Remove: true},
+			{C: "a", Id: 2, Update: bson.M{}},
+			{C: "a", Id: 2, Insert: bson.M{}},
+			{C: "a", Id: 3, Assert: DocMissing},
+		}
+		revno := map[docKey]int64{{"a", 1}: -1, {"a", 2}: 0, {"a", 3}: -1}
+		So(assembledRevnos(ops, revno), ShouldResemble, []int64{-1, 2, 3, 0, 1, -1})
+		So(revno, ShouldResemble, map[docKey]int64{{"a", 1}: -4, {"a", 2}: 1, {"a", 3}: -1})
+	})
+}
+
+func TestTokens(t *testing.T) {
+	Convey("tokens hold the transaction id and nonce", t, func() {
+		id := bson.ObjectIdHex("5f0000000000000000000001")
+		tx := &transaction{Id: id}
+		tt := tokenFor(tx)
+		So(tt.id(), ShouldEqual, id)
+		So(tt.nonce(), ShouldHaveLength, 8)
+		So(tokenFor(tx), ShouldNotEqual, tt)
+
+		tx.Nonce = tt.nonce()
+		So(tx.token(), ShouldEqual, tt)
+		So(tx.String(), ShouldEqual, string(tt))
+
+		other := token("5f0000000000000000000002_0badcafe")
+		stale := token(id.Hex() + "_0badcafe")
+		pull := map[bson.ObjectId]*transaction{id: tx}
+		So(tokensToPull([]token{stale, other, tt}, pull, tt), ShouldResemble, []token{stale})
+		So(hasToken([]token{stale, other}, tt), ShouldBeFalse)
+	})
+}
+
+func TestChangeDocs(t *testing.T) {
+	Convey("change documents are extended with the txn fields", t, func() {
+		d, err := objToDoc(bson.M{"$set": bson.M{"n": 1}})
+		So(err, ShouldBeNil)
+		d, err = addToDoc(d, "$set", bson.D{{Key: "txn-revno", Value: int64(2)}})
+		So(err, ShouldBeNil)
+		d, err = addToDoc(d, "$pullAll", bson.D{{Key: "txn-queue", Value: []token{"t"}}})
+		So(err, ShouldBeNil)
+		So(d, ShouldResemble, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "n", Value: int32(1)}, {Key: "txn-revno", Value: int64(2)}}},
+			{Key: "$pullAll", Value: bson.D{{Key: "txn-queue", Value: []token{"t"}}}},
+		})
+		_, err = addToDoc(bson.D{{Key: "$set", Value: 1}}, "$set", bson.D{})
+		So(err, ShouldNotBeNil)
+
+		d = setInDoc(bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: 1}}, bson.D{{Key: "_id", Value: 2}, {Key: "txn-revno", Value: 2}})
+		So(d, ShouldResemble, bson.D{{Key: "_id", Value: 2}, {Key: "n", Value: 1}, {Key: "txn-revno", Value: 2}})
+
+		logDoc := bson.D{{Key: "_id", Value: 1}}
+		logDoc = addToLog(logDoc, &Op{C: "a", Id: 1}, 2)
+		logDoc = addToLog(logDoc, &Op{C: "b", Id: 2}, -1)
+		logDoc = addToLog(logDoc, &Op{C: "a", Id: 3}, 3)
+		So(logDoc, ShouldResemble, bson.D{
+			{Key: "_id", Value: 1},
+			{Key: "a", Value: bson.D{{Key: "d", Value: []interface{}{1, 3}}, {Key: "r", Value: []int64{2, 3}}}},
+			{Key: "b", Value: bson.D{{Key: "d", Value: []interface{}{2}}, {Key: "r", Value: []int64{-1}}}},
+		})
+	})
+}
+
+func TestTransactionFormat(t *testing.T) {
+	Convey("transactions are stored in the format of the original package", t, func() {
+		id := bson.ObjectIdHex("5f0000000000000000000001")
+		data, err := bson.Marshal(&transaction{
+			Id:    id,
+			State: tpreparing,
+			Ops: []Op{
+				{C: "accounts", Id: "bob", Assert: DocExists, Update: bson.M{"$inc": bson.M{"n": 1}}},
+				{C: "accounts", Id: 1, Insert: bson.M{"n": 1}},
+				{C: "accounts", Id: 2, Remove: true},
+			},
+		})
+		So(err, ShouldBeNil)
+		var doc bson.D
+		So(bson.Unmarshal(data, &doc), ShouldBeNil)
+		So(doc, ShouldResemble, bson.D{
+			{Key: "_id", Value: id},
+			{Key: "s", Value: int32(1)},
+			{Key: "o", Value: bson.A{
+				bson.D{{Key: "c", Value: "accounts"}, {Key: "d", Value: "bob"}, {Key: "a", Value: "d+"},
+					{Key: "u", Value: bson.D{{Key: "$inc", Value: bson.D{{Key: "n", Value: int32(1)}}}}}},
+				bson.D{{Key: "c", Value: "accounts"}, {Key: "d", Value: int32(1)}, {Key: "i", Value: bson.D{{Key: "n", Value: int32(1)}}}},
+				bson.D{{Key: "c", Value: "accounts"}, {Key: "d", Value: int32(2)}, {Key: "r", Value: true}},
+			}},
+		})
+
+		var loaded transaction
+		So(bson.Unmarshal(data, &loaded), ShouldBeNil)
+		So(loaded.docKeys(), ShouldResemble, docKeys{{"accounts", "bob"}, {"accounts", 1}, {"accounts", 2}})
+
+		data, err = bson.Marshal(bson.M{"_id": docKey{"accounts", 1}})
+		So(err, ShouldBeNil)
+		So(bson.Unmarshal(data, &doc), ShouldBeNil)
+		So(doc, ShouldResemble, bson.D{{Key: "_id", Value: bson.D{{Key: "c", Value: "accounts"}, {Key: "id", Value: int32(1)}}}})
+	})
+}
+
+type Account struct {
+	Id      int `bson:"_id"`
+	Balance int
+}
+
+func TestRunner_DocExists(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+
+		exists := []Op{{C: "accounts", Id: 0, Assert: DocExists}}
+		missing := []Op{{C: "accounts", Id: 0, Assert: DocMissing}}
+		So(runner.Run(exists, bson.NilObjectID, nil), ShouldBeNil)
+		So(runner.Run(missing, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+
+		So(accounts.RemoveId(0), ShouldBeNil)
+		So(runner.Run(exists, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+		So(runner.Run(missing, bson.NilObjectID, nil), ShouldBeNil)
+	})
+}
+
+func TestRunner_Insert(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+
+		// Inserting an existing document leaves it alone.
+		ops := []Op{{C: "accounts", Id: 0, Insert: bson.M{"balance": 200}}}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 300)
+
+		ops[0].Id = 1
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(1).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 200)
+
+		// Asserting the document is missing aborts the insert instead.
+		ops[0].Assert = DocMissing
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+	})
+}
+
+func TestRunner_InsertStructId(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+
+		type id struct {
+			FirstName string
+			LastName  string
+		}
+		ops := []Op{
+			{C: "accounts", Id: id{"John", "Jones"}, Assert: DocMissing, Insert: bson.M{"balance": 200}},
+			{C: "accounts", Id: id{"Sally", "Smith"}, Assert: DocMissing, Insert: bson.M{"balance": 800}},
+		}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		n, err := accounts.Find(nil).Count()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+	})
+}
+
+func TestRunner_Remove(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+
+		ops := []Op{{C: "accounts", Id: 0, Remove: true}}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(0).One(nil), ShouldEqual, mgo.ErrNotFound)
+
+		// Removing a missing document is fine unless asserted otherwise.
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		ops[0].Assert = DocExists
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+	})
+}
+
+func TestRunner_Update(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 200}), ShouldBeNil)
+		So(accounts.Insert(bson.M{"_id": 1, "balance": 200}), ShouldBeNil)
+
+		ops := []Op{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}}}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 300)
+
+		// Updating a missing document is a no-op.
+		ops[0].Id = 2
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(2).One(nil), ShouldEqual, mgo.ErrNotFound)
+
+		// Failed assertions abort all the operations.
+		ops = []Op{
+			{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": -100}}},
+			{C: "accounts", Id: 1, Assert: bson.M{"balance": bson.M{"$gte": 300}}, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+		}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldEqual, ErrAborted)
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 300)
+		So(accounts.FindId(1).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 200)
+	})
+}
+
+func TestRunner_InsertUpdateRemove(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+
+		ops := []Op{
+			{C: "accounts", Id: 0, Insert: bson.M{"_id": 0, "balance": 200}},
+			{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+		}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 300)
+
+		// The insert is a no-op the second time around.
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 400)
+
+		ops = []Op{
+			{C: "accounts", Id: 0, Remove: true},
+			{C: "accounts", Id: 0, Insert: bson.M{"balance": 100}},
+			{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 1}}},
+		}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 101)
+
+		// Revisions carry over documents removed and inserted again.
+		var revno struct {
+			Revno int64 `bson:"txn-revno"`
+		}
+		So(accounts.FindId(0).One(&revno), ShouldBeNil)
+		So(revno.Revno, ShouldEqual, 7)
+	})
+}
+
+func TestRunner_Info(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		runner := NewRunner(tc)
+
+		id := bson.NewObjectId()
+		ops := []Op{{C: "accounts", Id: 0, Assert: DocMissing}}
+		So(runner.Run(ops, id, bson.M{"n": 42}), ShouldBeNil)
+
+		var stored struct {
+			Info struct{ N int } `bson:"i"`
+		}
+		So(tc.FindId(id).One(&stored), ShouldBeNil)
+		So(stored.Info.N, ShouldEqual, 42)
+	})
+}
+
+func TestRunner_AssertNestedOr(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+		So(accounts.Insert(bson.M{"_id": 1, "k": 3}), ShouldBeNil)
+
+		ops := []Op{{
+			C:      "accounts",
+			Id:     1,
+			Assert: bson.D{{Key: "$or", Value: []bson.M{{"$or": []bson.M{{"k": 3}, {"k": 4}}}, {"k": 5}}}},
+			Update: bson.M{"$set": bson.M{"k": 4}},
+		}}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		var doc struct{ K int }
+		So(accounts.FindId(1).One(&doc), ShouldBeNil)
+		So(doc.K, ShouldEqual, 4)
+	})
+}
+
+func TestRunner_VerifyFieldOrdering(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		accounts := db.C("accounts")
+		runner := NewRunner(db.C("txns"))
+
+		fields := bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}, {Key: "c", Value: 3}}
+		ops := []Op{{C: "accounts", Id: 0, Insert: fields}}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+
+		var doc bson.D
+		So(accounts.FindId(0).Select(bson.M{"a": 1, "b": 1, "c": 1}).One(&doc), ShouldBeNil)
+		So(doc, ShouldResemble, bson.D{
+			{Key: "_id", Value: int32(0)},
+			{Key: "a", Value: int32(1)},
+			{Key: "b", Value: int32(2)},
+			{Key: "c", Value: int32(3)},
+		})
+	})
+}
+
+func TestRunner_ChangeLog(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		chglog := db.C("chglog")
+		runner := NewRunner(db.C("txns"))
+		runner.ChangeLog(chglog)
+
+		ops := []Op{
+			{C: "debts", Id: 0, Assert: DocMissing},
+			{C: "accounts", Id: 0, Insert: bson.M{"balance": 300}},
+			{C: "accounts", Id: 1, Insert: bson.M{"balance": 300}},
+			{C: "people", Id: "joe", Insert: bson.M{"accounts": []int{0, 1}}},
+		}
+		id := bson.NewObjectId()
+		So(runner.Run(ops, id, nil), ShouldBeNil)
+
+		type IdList []interface{}
+		type Log struct {
+			Docs   IdList  `bson:"d"`
+			Revnos []int64 `bson:"r"`
+		}
+		var m map[string]*Log
+		So(chglog.FindId(id).Select(bson.M{"_id": 0}).One(&m), ShouldBeNil)
+		So(m["accounts"], ShouldResemble, &Log{IdList{int32(0), int32(1)}, []int64{2, 2}})
+		So(m["people"], ShouldResemble, &Log{IdList{"joe"}, []int64{2}})
+		So(m["debts"], ShouldBeNil)
+
+		ops = []Op{
+			{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+			{C: "accounts", Id: 1, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+		}
+		id = bson.NewObjectId()
+		So(runner.Run(ops, id, nil), ShouldBeNil)
+		m = nil
+		So(chglog.FindId(id).Select(bson.M{"_id": 0}).One(&m), ShouldBeNil)
+		So(m["accounts"], ShouldResemble, &Log{IdList{int32(0), int32(1)}, []int64{3, 3}})
+
+		ops = []Op{
+			{C: "accounts", Id: 0, Remove: true},
+			{C: "people", Id: "joe", Remove: true},
+		}
+		id = bson.NewObjectId()
+		So(runner.Run(ops, id, nil), ShouldBeNil)
+		m = nil
+		So(chglog.FindId(id).Select(bson.M{"_id": 0}).One(&m), ShouldBeNil)
+		So(m["accounts"], ShouldResemble, &Log{IdList{int32(0)}, []int64{-4}})
+		So(m["people"], ShouldResemble, &Log{IdList{"joe"}, []int64{-3}})
+	})
+}
+
+func TestRunner_PurgeMissing(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		accounts := db.C("accounts")
+		runner := NewRunner(tc)
+
+		for _, id := range []int{0, 1, 2} {
+			So(accounts.Insert(bson.M{"_id": id, "balance": 300}), ShouldBeNil)
+		}
+		var ids []bson.ObjectId
+		for _, ops := range [][]Op{
+			{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}}},
+			{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+				{C: "accounts", Id: 1, Update: bson.M{"$inc": bson.M{"balance": 100}}}},
+			{{C: "accounts", Id: 3, Insert: bson.M{"balance": 100}}},
+			{{C: "accounts", Id: 3, Remove: true}},
+		} {
+			id := bson.NewObjectId()
+			So(runner.Run(ops, id, nil), ShouldBeNil)
+			ids = append(ids, id)
+		}
+
+		// Lose the transactions, except the removal leaving 3 stashed.
+		for _, id := range ids[:3] {
+			So(tc.RemoveId(id), ShouldBeNil)
+		}
+		So(accounts.UpdateId(2, bson.M{"$push": bson.M{"txn-queue": ids[1].Hex() + "_0badcafe"}}), ShouldBeNil)
+
+		So(runner.PurgeMissing("accounts"), ShouldBeNil)
+		var docs []struct {
+			Queue []string `bson:"txn-queue"`
+		}
+		So(accounts.Find(nil).All(&docs), ShouldBeNil)
+		So(docs, ShouldHaveLength, 3)
+		for _, doc := range docs {
+			So(doc.Queue, ShouldBeEmpty)
+		}
+
+		// Transactions run on the purged documents again.
+		ops := []Op{
+			{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 1}}},
+			{C: "accounts", Id: 2, Update: bson.M{"$inc": bson.M{"balance": 1}}},
+			{C: "accounts", Id: 3, Insert: bson.M{"balance": 1}},
+		}
+		So(runner.Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+		var account Account
+		So(accounts.FindId(2).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 301)
+		So(accounts.FindId(3).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 1)
+	})
+}
+
+// crashed inserts a transaction with ops as Run does, as if the runner
+// crashed right after, and returns its id. If prepared is set, the
+// transaction is also queued in the documents of ops, which must exist,
+// as if the runner crashed once it prepared them.
+func crashed(tc *mgo.Collection, ops []Op, prepared bool) bson.ObjectId {
+	t := &transaction{Id: bson.NewObjectId(), State: tpreparing, Ops: ops}
+	if prepared {
+		t.State, t.Nonce = tprepared, newNonce()
+		for _, dkey := range t.docKeys() {
+			udoc := bson.M{"$addToSet": bson.M{"txn-queue": t.token()}}
+			So(tc.Database().C(dkey.C).UpdateId(dkey.Id, udoc), ShouldBeNil)
+		}
+	}
+	So(tc.Insert(t), ShouldBeNil)
+	return t.Id
+}
+
+func TestRunner_Resume(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		accounts := db.C("accounts")
+		runner := NewRunner(tc)
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+		So(accounts.Insert(bson.M{"_id": 1, "balance": 300}), ShouldBeNil)
+
+		So(runner.Resume(bson.NewObjectId()), ShouldEqual, mgo.ErrNotFound)
+
+		transfer := []Op{
+			{C: "accounts", Id: 0, Assert: bson.M{"balance": bson.M{"$gte": 100}}, Update: bson.M{"$inc": bson.M{"balance": -100}}},
+			{C: "accounts", Id: 1, Update: bson.M{"$inc": bson.M{"balance": 100}}},
+		}
+		id := crashed(tc, transfer, true)
+		So(runner.Resume(id), ShouldBeNil)
+		So(runner.Resume(id), ShouldBeNil)
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 200)
+		So(accounts.FindId(1).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 400)
+
+		aborted := crashed(tc, []Op{{C: "accounts", Id: 0, Assert: DocMissing}}, false)
+		So(runner.Resume(aborted), ShouldEqual, ErrAborted)
+		So(runner.Resume(aborted), ShouldEqual, ErrAborted)
+	})
+}
+
+func TestRunner_ResumeAll(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		accounts := db.C("accounts")
+		runner := NewRunner(tc)
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+
+		inc := []Op{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 100}}}}
+		crashed(tc, inc, true)
+		crashed(tc, inc, false)
+		crashed(tc, []Op{{C: "accounts", Id: 0, Assert: DocMissing}}, false)
+		So(runner.ResumeAll(), ShouldBeNil)
+
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 500)
+		n, err := tc.Find(bson.M{"s": bson.M{"$nin": []state{tapplied, taborted}}}).Count()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+		n, err = tc.Find(bson.M{"s": taborted}).Count()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+	})
+}
+
+func TestRunner_RunResumesPending(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		accounts := db.C("accounts")
+		So(accounts.Insert(bson.M{"_id": 0, "balance": 300}), ShouldBeNil)
+
+		// Transactions queued in a document are completed, in order, by
+		// the next transaction touching it, on any runner.
+		id := crashed(tc, []Op{{C: "accounts", Id: 0, Update: bson.M{"$set": bson.M{"balance": 100}}}}, true)
+		ops := []Op{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 1}}}}
+		So(NewRunner(tc).Run(ops, bson.NilObjectID, nil), ShouldBeNil)
+
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 101)
+		var stored transaction
+		So(tc.FindId(id).One(&stored), ShouldBeNil)
+		So(stored.State, ShouldEqual, tapplied)
+	})
+}
+
+func TestRunner_SetServerTransactions(t *testing.T) {
+	ReplSetMongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("test")
+		tc := db.C("txns")
+		accounts := db.C("accounts")
+		chglog := db.C("chglog")
+		for _, c := range []*mgo.Collection{tc, db.C("txns.stash"), accounts, chglog} {
+			So(c.Create(&mgo.CollectionInfo{}), ShouldBeNil)
+		}
+		runner := NewRunner(tc)
+		runner.SetServerTransactions(true)
+		runner.ChangeLog(chglog)
+
+		ops := []Op{
+			{C: "accounts", Id: 0, Assert: DocMissing, Insert: bson.M{"balance": 300}},
+			{C: "accounts", Id: 1, Insert: bson.M{"balance": 300}},
+		}
+		id := bson.NewObjectId()
+		So(runner.Run(ops, id, nil), ShouldBeNil)
+		var stored transaction
+		So(tc.FindId(id).One(&stored), ShouldBeNil)
+		So(stored.State, ShouldEqual, tapplied)
+		So(stored.Revnos, ShouldResemble, []int64{-1, -1})
+		var m map[string]struct {
+			Revnos []int64 `bson:"r"`
+		}
+		So(chglog.FindId(id).Select(bson.M{"_id": 0}).One(&m), ShouldBeNil)
+		So(m["accounts"].Revnos, ShouldResemble, []int64{2, 2})
+
+		transfer := []Op{
+			{C: "accounts", Id: 0, Assert: bson.M{"balance": bson.M{"$gte": 200}}, Update: bson.M{"$inc": bson.M{"balance": -200}}},
+			{C: "accounts", Id: 1, Update: bson.M{"$inc": bson.M{"balance": 200}}},
+		}
+		So(runner.Run(transfer, bson.NilObjectID, nil), ShouldBeNil)
+		id = bson.NewObjectId()
+		So(runner.Run(transfer, id, nil), ShouldEqual, ErrAborted)
+		So(tc.FindId(id).One(&stored), ShouldBeNil)
+		So(stored.State, ShouldEqual, taborted)
+
+		var account Account
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 100)
+		So(accounts.FindId(1).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 500)
+
+		// Documents with pending transactions are left to the client side
+		// protocol, which completes them first.
+		pending := crashed(tc, []Op{{C: "accounts", Id: 0, Update: bson.M{"$set": bson.M{"balance": 0}}}}, true)
+		So(runner.Run([]Op{{C: "accounts", Id: 0, Remove: true}}, bson.NilObjectID, nil), ShouldBeNil)
+		So(tc.FindId(pending).One(&stored), ShouldBeNil)
+		So(stored.State, ShouldEqual, tapplied)
+		So(accounts.FindId(0).One(nil), ShouldEqual, mgo.ErrNotFound)
+
+		// Client side runners pick up where the server transactions left.
+		client := NewRunner(tc)
+		So(client.Run([]Op{{C: "accounts", Id: 0, Assert: DocMissing, Insert: bson.M{"balance": 1}}}, bson.NilObjectID, nil), ShouldBeNil)
+		So(runner.Run([]Op{{C: "accounts", Id: 0, Update: bson.M{"$inc": bson.M{"balance": 1}}}}, bson.NilObjectID, nil), ShouldBeNil)
+		So(accounts.FindId(0).One(&account), ShouldBeNil)
+		So(account.Balance, ShouldEqual, 2)
+		var queue struct {
+			Queue []string `bson:"txn-queue"`
+		}
+		So(accounts.FindId(0).One(&queue), ShouldBeNil)
+		So(queue.Queue, ShouldBeEmpty)
+	})
+}
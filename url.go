@@ -0,0 +1,322 @@
+package mgo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// ParseURL parses a MongoDB URL as accepted by the Dial function and returns
+// a value suitable for providing into DialWithInfo.
+//
+// The following connection options are supported, in addition to the mgo
+// specific connect=direct, connect=replicaSet and gssapiServiceName:
+//
+//	authSource, authMechanism, authMechanismProperties, replicaSet,
+//	directConnection, maxPoolSize, minPoolSize, maxIdleTimeMS,
+//	waitQueueTimeoutMS, appName, readPreference, readPreferenceTags,
+//	w, wtimeoutMS, journal, readConcernLevel, connectTimeoutMS and
+//	socketTimeoutMS.
+//
+// Other options, including the ones for TLS, result in an error, as do
+// mongodb+srv URLs. Dial hands such connection strings to the driver
+// instead; with DialWithInfo, provide a DialServer function in the DialInfo
+// to establish TLS connections.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/connection-string/
+func ParseURL(url string) (*DialInfo, error) {
+	uinfo, err := extractURL(url)
+	if err != nil {
+		return nil, err
+	}
+	info := &DialInfo{
+		Addrs:    uinfo.addrs,
+		Database: uinfo.db,
+		Username: uinfo.user,
+		Password: uinfo.pass,
+	}
+	var readPreferenceSet bool
+	readPreference := &ReadPreference{Mode: Primary}
+	for _, opt := range uinfo.options {
+		switch strings.ToLower(opt.key) {
+		case "authsource":
+			info.Source = opt.value
+		case "authmechanism":
+			info.Mechanism = opt.value
+		case "authmechanismproperties":
+			for _, pair := range strings.Split(opt.value, ",") {
+				kv := strings.SplitN(pair, ":", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("invalid authMechanismProperties: %q", opt.value)
+				}
+				switch kv[0] {
+				case "SERVICE_NAME":
+					info.Service = kv[1]
+				case "SERVICE_HOST":
+					info.ServiceHost = kv[1]
+				default:
+					return nil, fmt.Errorf("unsupported authMechanismProperties property: %q", kv[0])
+				}
+			}
+		case "gssapiservicename":
+			info.Service = opt.value
+		case "replicaset":
+			info.ReplicaSetName = opt.value
+		case "connect":
+			switch opt.value {
+			case "direct":
+				info.Direct = true
+			case "replicaSet", "automatic":
+				info.Direct = false
+			default:
+				return nil, &unsupportedURLError{fmt.Sprintf("unsupported connection URL option: %s=%s", opt.key, opt.value)}
+			}
+		case "directconnection":
+			if info.Direct, err = parseURLBool(opt); err != nil {
+				return nil, err
+			}
+		case "maxpoolsize":
+			if info.PoolLimit, err = parseURLInt(opt); err != nil {
+				return nil, err
+			}
+		case "minpoolsize":
+			if info.MinPoolSize, err = parseURLInt(opt); err != nil {
+				return nil, err
+			}
+		case "maxidletimems":
+			if info.MaxIdleTimeMS, err = parseURLInt(opt); err != nil {
+				return nil, err
+			}
+		case "waitqueuetimeoutms":
+			if info.PoolTimeout, err = parseURLDuration(opt); err != nil {
+				return nil, err
+			}
+		case "appname":
+			if len(opt.value) > 128 {
+				return nil, fmt.Errorf("appName too long, must be < 128 bytes: %s", opt.value)
+			}
+			info.AppName = opt.value
+		case "readpreference":
+			readPreferenceSet = true
+			if readPreference.Mode, err = readpref.ModeFromString(opt.value); err != nil {
+				return nil, fmt.Errorf("bad value for readPreference: %s", opt.value)
+			}
+		case "readpreferencetags":
+			readPreferenceSet = true
+			var tags bson.D
+			for _, pair := range strings.Split(opt.value, ",") {
+				if pair == "" {
+					continue
+				}
+				kv := strings.SplitN(pair, ":", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return nil, fmt.Errorf("bad value for readPreferenceTags: %s", opt.value)
+				}
+				tags = append(tags, bson.E{Key: kv[0], Value: kv[1]})
+			}
+			readPreference.TagSets = append(readPreference.TagSets, tags)
+		case "w":
+			if w, err := strconv.Atoi(opt.value); err == nil {
+				info.Safe.W = w
+				info.Unacknowledged = w == 0
+			} else {
+				info.Safe.WMode = opt.value
+			}
+		case "wtimeoutms":
+			if info.Safe.WTimeout, err = parseURLInt(opt); err != nil {
+				return nil, err
+			}
+		case "journal":
+			if info.Safe.J, err = parseURLBool(opt); err != nil {
+				return nil, err
+			}
+		case "readconcernlevel":
+			info.Safe.RMode = opt.value
+		case "connecttimeoutms":
+			if info.Timeout, err = parseURLDuration(opt); err != nil {
+				return nil, err
+			}
+		case "sockettimeoutms":
+			if info.ReadTimeout, err = parseURLDuration(opt); err != nil {
+				return nil, err
+			}
+			info.WriteTimeout = info.ReadTimeout
+		default:
+			return nil, &unsupportedURLError{fmt.Sprintf("unsupported connection URL option: %s=%s", opt.key, opt.value)}
+		}
+	}
+	if readPreferenceSet {
+		if readPreference.Mode == Primary && len(readPreference.TagSets) > 0 {
+			return nil, errors.New("readPreferenceTags may not be specified when readPreference is primary")
+		}
+		info.ReadPreference = readPreference
+	}
+	return info, nil
+}
+
+// dialInfoFromURL returns the DialInfo Dial uses for url, with the given
+// timeout. The socket timeout defaults to socketTimeout if url doesn't set
+// it. Connection strings ParseURL doesn't support are left to the driver
+// if it knows all of their options.
+func dialInfoFromURL(url string, timeout, socketTimeout time.Duration) (*DialInfo, error) {
+	info, err := ParseURL(url)
+	if _, ok := err.(*unsupportedURLError); ok {
+		info, err = driverDialInfo(url, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = timeout
+	if info.ReadTimeout == 0 && info.WriteTimeout == 0 {
+		info.ReadTimeout, info.WriteTimeout = socketTimeout, socketTimeout
+	}
+	return info, nil
+}
+
+// driverDialInfo returns a DialInfo obtaining the client options from url
+// with the driver, or parseErr if the driver doesn't know some option.
+func driverDialInfo(url string, parseErr error) (*DialInfo, error) {
+	if !strings.HasPrefix(url, "mongodb://") && !strings.HasPrefix(url, "mongodb+srv://") {
+		url = "mongodb://" + url
+	}
+	cs, err := connstring.ParseAndValidate(url)
+	if err != nil {
+		return nil, err
+	}
+	if len(cs.UnknownOptions) > 0 {
+		return nil, parseErr
+	}
+	opts := options.Client().ApplyURI(url)
+	info := &DialInfo{Database: cs.Database, uri: url}
+	if safe := safeFromConcerns(opts.WriteConcern, opts.ReadConcern); safe != nil {
+		info.Safe = *safe
+	} else {
+		info.Unacknowledged = true
+		info.Safe.RMode = safeFromConcerns(nil, opts.ReadConcern).RMode
+	}
+	if cs.SocketTimeoutSet {
+		info.ReadTimeout, info.WriteTimeout = cs.SocketTimeout, cs.SocketTimeout
+	}
+	if rp := opts.ReadPreference; rp != nil {
+		info.ReadPreference = &ReadPreference{Mode: rp.Mode()}
+		for _, set := range rp.TagSets() {
+			tags := make(bson.D, 0, len(set))
+			for _, t := range set {
+				tags = append(tags, bson.E{Key: t.Name, Value: t.Value})
+			}
+			info.ReadPreference.TagSets = append(info.ReadPreference.TagSets, tags)
+		}
+	}
+	return info, nil
+}
+
+// unsupportedURLError reports a connection string, or an option of it,
+// that ParseURL doesn't support.
+type unsupportedURLError struct {
+	msg string
+}
+
+func (e *unsupportedURLError) Error() string {
+	return e.msg
+}
+
+type urlInfo struct {
+	addrs   []string
+	user    string
+	pass    string
+	db      string
+	options []urlInfoOption
+}
+
+type urlInfoOption struct {
+	key   string
+	value string
+}
+
+func extractURL(s string) (*urlInfo, error) {
+	if strings.HasPrefix(s, "mongodb+srv://") {
+		return nil, &unsupportedURLError{"mongodb+srv URLs are not supported by ParseURL, use Dial instead"}
+	}
+	s = strings.TrimPrefix(s, "mongodb://")
+	info := &urlInfo{options: []urlInfoOption{}}
+
+	if c := strings.Index(s, "?"); c != -1 {
+		for _, pair := range strings.FieldsFunc(s[c+1:], isOptSep) {
+			l := strings.SplitN(pair, "=", 2)
+			// An empty readPreferenceTags matches any server.
+			if len(l) != 2 || l[0] == "" || l[1] == "" && !strings.EqualFold(l[0], "readPreferenceTags") {
+				return nil, errors.New("connection option must be key=value: " + pair)
+			}
+			value, err := url.QueryUnescape(l[1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot unescape value of connection option %q: %q", l[0], l[1])
+			}
+			info.options = append(info.options, urlInfoOption{key: l[0], value: value})
+		}
+		s = s[:c]
+	}
+	if c := strings.LastIndex(s, "@"); c != -1 {
+		pair := strings.SplitN(s[:c], ":", 2)
+		if len(pair) > 2 || pair[0] == "" {
+			return nil, errors.New("credentials must be provided as user:pass@host")
+		}
+		var err error
+		info.user, err = url.QueryUnescape(pair[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot unescape username in URL: %q", pair[0])
+		}
+		if len(pair) > 1 {
+			info.pass, err = url.QueryUnescape(pair[1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot unescape password in URL")
+			}
+		}
+		s = s[c+1:]
+	}
+	if c := strings.Index(s, "/"); c != -1 {
+		info.db = s[c+1:]
+		s = s[:c]
+	}
+	if s == "" {
+		return nil, errors.New("no servers provided in URL")
+	}
+	info.addrs = strings.Split(s, ",")
+	return info, nil
+}
+
+func isOptSep(c rune) bool {
+	return c == ';' || c == '&'
+}
+
+func parseURLInt(opt urlInfoOption) (int, error) {
+	n, err := strconv.Atoi(opt.value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad value for %s: %s", opt.key, opt.value)
+	}
+	return n, nil
+}
+
+func parseURLDuration(opt urlInfoOption) (time.Duration, error) {
+	n, err := parseURLInt(opt)
+	return time.Duration(n) * time.Millisecond, err
+}
+
+func parseURLBool(opt urlInfoOption) (bool, error) {
+	switch opt.value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad value for %s: %s", opt.key, opt.value)
+}
@@ -0,0 +1,370 @@
+package mgo
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/yaziming/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// User represents a MongoDB user.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/method/db.createUser/
+//	https://docs.mongodb.com/manual/reference/built-in-roles/
+type User struct {
+	// Username is how the user identifies itself to the system.
+	Username string `bson:"user"`
+
+	// Password is the plaintext password for the user. If set, the
+	// server hashes it with each mechanism in Mechanisms. Password
+	// and PasswordHash are mutually exclusive.
+	Password string `bson:",omitempty"`
+
+	// PasswordHash is the MD5 hash of Username+":mongo:"+Password, as
+	// stored by the original mgo package and the legacy MONGODB-CR
+	// mechanism. It may only be used with the SCRAM-SHA-1 mechanism.
+	PasswordHash string `bson:"pwd,omitempty"`
+
+	// CustomData holds arbitrary data admins decide to associate
+	// with this user, such as the full name or employee id.
+	CustomData interface{} `bson:"customData,omitempty"`
+
+	// Roles indicates the set of roles the user will be provided.
+	// See the Role constants. UpsertUser keeps the roles of existing
+	// users if both Roles and OtherDBRoles are nil.
+	Roles []Role `bson:"roles"`
+
+	// OtherDBRoles allows assigning roles in other databases from
+	// user documents inserted in the admin database. This field
+	// only works in the admin database.
+	OtherDBRoles map[string][]Role `bson:"otherDBRoles,omitempty"`
+
+	// Mechanisms holds the SCRAM mechanisms the credentials of the user
+	// are created for, "SCRAM-SHA-1" and "SCRAM-SHA-256". If empty, the
+	// server picks the mechanisms it supports when creating the user,
+	// and keeps the current ones when updating it.
+	Mechanisms []string `bson:"mechanisms,omitempty"`
+}
+
+// Role is the name of a role a user may be granted.
+type Role string
+
+const (
+	// Relevant documentation:
+	//
+	//     https://docs.mongodb.com/manual/reference/built-in-roles/
+	//
+
+	RoleRoot           Role = "root"
+	RoleRead           Role = "read"
+	RoleReadAny        Role = "readAnyDatabase"
+	RoleReadWrite      Role = "readWrite"
+	RoleReadWriteAny   Role = "readWriteAnyDatabase"
+	RoleDBAdmin        Role = "dbAdmin"
+	RoleDBAdminAny     Role = "dbAdminAnyDatabase"
+	RoleDBOwner        Role = "dbOwner"
+	RoleUserAdmin      Role = "userAdmin"
+	RoleUserAdminAny   Role = "userAdminAnyDatabase"
+	RoleClusterAdmin   Role = "clusterAdmin"
+	RoleClusterManager Role = "clusterManager"
+	RoleClusterMonitor Role = "clusterMonitor"
+	RoleHostManager    Role = "hostManager"
+	RoleBackup         Role = "backup"
+	RoleRestore        Role = "restore"
+)
+
+// DBRole refers to a role defined in a database. An empty DB refers to
+// the database the command is run against.
+type DBRole struct {
+	Role Role   `bson:"role"`
+	DB   string `bson:"db"`
+}
+
+// CustomRole represents a user-defined role.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/command/createRole/
+type CustomRole struct {
+	// Role is the name of the role.
+	Role Role `bson:"role"`
+
+	// DB is the database the role is defined in. It is set by RoleInfo
+	// and ignored by CreateRole, which defines the role in the database
+	// it is run against.
+	DB string `bson:"db,omitempty"`
+
+	// Privileges holds the actions the role grants on resources.
+	Privileges []Privilege `bson:"privileges"`
+
+	// Roles holds the roles the role inherits privileges from.
+	Roles []DBRole `bson:"roles"`
+}
+
+// Privilege grants actions on a resource.
+type Privilege struct {
+	Resource Resource `bson:"resource"`
+	Actions  []string `bson:"actions"`
+}
+
+// Resource identifies the target of a privilege. Either Cluster is set,
+// AnyResource is set, or DB and Collection are, where an empty DB means
+// all databases and an empty Collection all the collections of DB, except
+// for system collections.
+type Resource struct {
+	DB          string `bson:"db"`
+	Collection  string `bson:"collection"`
+	Cluster     bool   `bson:"cluster,omitempty"`
+	AnyResource bool   `bson:"anyResource,omitempty"`
+}
+
+// MarshalBSON implements bson.Marshaler, omitting DB and Collection for
+// the cluster and any-resource resources.
+func (r Resource) MarshalBSON() ([]byte, error) {
+	switch {
+	case r.Cluster:
+		return bson.Marshal(bson.D{{Key: "cluster", Value: true}})
+	case r.AnyResource:
+		return bson.Marshal(bson.D{{Key: "anyResource", Value: true}})
+	}
+	return bson.Marshal(bson.D{{Key: "db", Value: r.DB}, {Key: "collection", Value: r.Collection}})
+}
+
+// userNotFound and roleNotFound are the codes of the server errors
+// reporting unknown users and roles.
+const (
+	userNotFound = 11
+	roleNotFound = 31
+)
+
+// isCommandCode reports whether err is a command error with code.
+func isCommandCode(err error, code int32) bool {
+	cerr, ok := err.(mongo.CommandError)
+	return ok && cerr.Code == code
+}
+
+// UpsertUser updates the authentication credentials and the roles for
+// a MongoDB user within the d database. If the named user doesn't exist
+// it will be created.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/command/createUser/
+//	https://docs.mongodb.com/manual/reference/command/updateUser/
+func (d *Database) UpsertUser(user *User) error {
+	if user.Username == "" {
+		return errors.New("user has no Username")
+	}
+	if user.Password != "" && user.PasswordHash != "" {
+		return errors.New("user has both Password and PasswordHash set")
+	}
+	if len(user.OtherDBRoles) > 0 && d.Name() != "admin" {
+		return errors.New("user with OtherDBRoles is only supported in the admin database")
+	}
+	err := d.runUserCmd("updateUser", user)
+	if isCommandCode(err, userNotFound) {
+		return d.runUserCmd("createUser", user)
+	}
+	return err
+}
+
+// runUserCmd runs the createUser or updateUser command for user.
+func (d *Database) runUserCmd(cmdName string, user *User) error {
+	cmd := bson.D{{Key: cmdName, Value: user.Username}}
+	roles := make(bson.A, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role)
+	}
+	dbs := make([]string, 0, len(user.OtherDBRoles))
+	for db := range user.OtherDBRoles {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		for _, role := range user.OtherDBRoles[db] {
+			roles = append(roles, DBRole{Role: role, DB: db})
+		}
+	}
+	// updateUser keeps the roles of the user when nil, while createUser
+	// requires them.
+	if user.Roles != nil || user.OtherDBRoles != nil || cmdName == "createUser" {
+		cmd = append(cmd, bson.E{Key: "roles", Value: roles})
+	}
+	switch {
+	case user.Password != "":
+		cmd = append(cmd, bson.E{Key: "pwd", Value: user.Password})
+	case user.PasswordHash != "":
+		cmd = append(cmd,
+			bson.E{Key: "pwd", Value: user.PasswordHash},
+			bson.E{Key: "digestPassword", Value: false},
+		)
+		if len(user.Mechanisms) == 0 {
+			cmd = append(cmd, bson.E{Key: "mechanisms", Value: []string{"SCRAM-SHA-1"}})
+		}
+	}
+	if user.CustomData != nil {
+		cmd = append(cmd, bson.E{Key: "customData", Value: user.CustomData})
+	}
+	if len(user.Mechanisms) > 0 {
+		cmd = append(cmd, bson.E{Key: "mechanisms", Value: user.Mechanisms})
+	}
+	return d.Run(cmd, nil)
+}
+
+// AddUser creates or updates the authentication credentials of user within
+// the d database, granting it the read role if readOnly is set and the
+// readWrite role otherwise. It is kept for compatibility with the original
+// mgo package; new code should use UpsertUser.
+func (d *Database) AddUser(username, password string, readOnly bool) error {
+	role := RoleReadWrite
+	if readOnly {
+		role = RoleRead
+	}
+	return d.UpsertUser(&User{Username: username, Password: password, Roles: []Role{role}})
+}
+
+// RemoveUser removes the authentication credentials of user from the
+// database, returning ErrNotFound if the user doesn't exist.
+func (d *Database) RemoveUser(username string) error {
+	err := d.Run(bson.D{{Key: "dropUser", Value: username}}, nil)
+	if isCommandCode(err, userNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// userInfo is the document describing a user in the result of usersInfo.
+type userInfo struct {
+	Username   string      `bson:"user"`
+	DB         string      `bson:"db"`
+	CustomData interface{} `bson:"customData"`
+	Roles      []DBRole    `bson:"roles"`
+	Mechanisms []string    `bson:"mechanisms"`
+}
+
+// user converts info into a User of the db database, moving the roles
+// defined in other databases to OtherDBRoles.
+func (info *userInfo) user() User {
+	user := User{Username: info.Username, CustomData: info.CustomData, Mechanisms: info.Mechanisms}
+	for _, role := range info.Roles {
+		if role.DB == info.DB {
+			user.Roles = append(user.Roles, role.Role)
+			continue
+		}
+		if user.OtherDBRoles == nil {
+			user.OtherDBRoles = make(map[string][]Role)
+		}
+		user.OtherDBRoles[role.DB] = append(user.OtherDBRoles[role.DB], role.Role)
+	}
+	return user
+}
+
+// UserInfo returns the user named username within the d database, or
+// ErrNotFound if there is no such user. The password of the user is never
+// returned.
+func (d *Database) UserInfo(username string) (*User, error) {
+	users, err := d.usersInfo(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// UsersInfo returns all the users of the d database.
+func (d *Database) UsersInfo() ([]User, error) {
+	return d.usersInfo(1)
+}
+
+func (d *Database) usersInfo(filter interface{}) ([]User, error) {
+	var result struct {
+		Users []userInfo `bson:"users"`
+	}
+	if err := d.Run(bson.D{{Key: "usersInfo", Value: filter}}, &result); err != nil {
+		return nil, err
+	}
+	users := make([]User, len(result.Users))
+	for i := range result.Users {
+		users[i] = result.Users[i].user()
+	}
+	return users, nil
+}
+
+// GrantRolesToUser grants roles to the user named username within the d
+// database, returning ErrNotFound if the user doesn't exist.
+func (d *Database) GrantRolesToUser(username string, roles ...DBRole) error {
+	return d.runRolesCmd("grantRolesToUser", username, roles)
+}
+
+// RevokeRolesFromUser revokes roles from the user named username within the
+// d database, returning ErrNotFound if the user doesn't exist.
+func (d *Database) RevokeRolesFromUser(username string, roles ...DBRole) error {
+	return d.runRolesCmd("revokeRolesFromUser", username, roles)
+}
+
+func (d *Database) runRolesCmd(cmdName, username string, roles []DBRole) error {
+	err := d.Run(bson.D{{Key: cmdName, Value: username}, {Key: "roles", Value: d.dbRoles(roles)}}, nil)
+	if isCommandCode(err, userNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// dbRoles returns roles with the empty databases set to the d database.
+func (d *Database) dbRoles(roles []DBRole) []DBRole {
+	result := make([]DBRole, len(roles))
+	for i, role := range roles {
+		if role.DB == "" {
+			role.DB = d.Name()
+		}
+		result[i] = role
+	}
+	return result
+}
+
+// CreateRole creates role within the d database.
+func (d *Database) CreateRole(role *CustomRole) error {
+	if role.Role == "" {
+		return errors.New("role has no name")
+	}
+	privileges := role.Privileges
+	if privileges == nil {
+		privileges = []Privilege{}
+	}
+	return d.Run(bson.D{
+		{Key: "createRole", Value: role.Role},
+		{Key: "privileges", Value: privileges},
+		{Key: "roles", Value: d.dbRoles(role.Roles)},
+	}, nil)
+}
+
+// DropRole removes the role named name from the d database, returning
+// ErrNotFound if the role doesn't exist.
+func (d *Database) DropRole(name Role) error {
+	err := d.Run(bson.D{{Key: "dropRole", Value: name}}, nil)
+	if isCommandCode(err, roleNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RoleInfo returns the role named name within the d database, including
+// its privileges, or ErrNotFound if there is no such role.
+func (d *Database) RoleInfo(name Role) (*CustomRole, error) {
+	var result struct {
+		Roles []CustomRole `bson:"roles"`
+	}
+	cmd := bson.D{{Key: "rolesInfo", Value: name}, {Key: "showPrivileges", Value: true}}
+	if err := d.Run(cmd, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Roles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &result.Roles[0], nil
+}
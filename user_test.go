@@ -0,0 +1,138 @@
+package mgo
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/yaziming/mgo/bson"
+	"testing"
+)
+
+func TestResource_MarshalBSON(t *testing.T) {
+	Convey("resources only hold the fields the server expects", t, func() {
+		for _, c := range []struct {
+			resource Resource
+			doc      bson.D
+			decoded  Resource
+		}{
+			{Resource{}, bson.D{{Key: "db", Value: ""}, {Key: "collection", Value: ""}}, Resource{}},
+			{Resource{DB: "mydb", Collection: "orders"}, bson.D{{Key: "db", Value: "mydb"}, {Key: "collection", Value: "orders"}}, Resource{DB: "mydb", Collection: "orders"}},
+			{Resource{DB: "mydb", Cluster: true}, bson.D{{Key: "cluster", Value: true}}, Resource{Cluster: true}},
+			{Resource{AnyResource: true}, bson.D{{Key: "anyResource", Value: true}}, Resource{AnyResource: true}},
+		} {
+			data, err := bson.Marshal(Privilege{Resource: c.resource, Actions: []string{"find"}})
+			So(err, ShouldBeNil)
+			var doc struct {
+				Resource bson.D `bson:"resource"`
+			}
+			So(bson.Unmarshal(data, &doc), ShouldBeNil)
+			So(doc.Resource, ShouldResemble, c.doc)
+
+			var privilege Privilege
+			So(bson.Unmarshal(data, &privilege), ShouldBeNil)
+			So(privilege.Resource, ShouldResemble, c.decoded)
+		}
+	})
+}
+
+func TestUserInfo_User(t *testing.T) {
+	Convey("roles in other databases are moved to OtherDBRoles", t, func() {
+		info := &userInfo{
+			Username: "myuser",
+			DB:       "admin",
+			Roles: []DBRole{
+				{Role: RoleReadWrite, DB: "admin"},
+				{Role: RoleRead, DB: "db1"},
+				{Role: RoleDBAdmin, DB: "db1"},
+			},
+			Mechanisms: []string{"SCRAM-SHA-256"},
+		}
+		So(info.user(), ShouldResemble, User{
+			Username:     "myuser",
+			Roles:        []Role{RoleReadWrite},
+			OtherDBRoles: map[string][]Role{"db1": {RoleRead, RoleDBAdmin}},
+			Mechanisms:   []string{"SCRAM-SHA-256"},
+		})
+	})
+}
+
+func TestDatabase_UpsertUser(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("admin")
+
+		So(db.UpsertUser(&User{Password: "pass"}), ShouldNotBeNil)
+		So(db.UpsertUser(&User{Username: "myuser", Password: "pass", PasswordHash: "hash"}), ShouldNotBeNil)
+		So(ctx.mongo.DB("mydb").UpsertUser(&User{Username: "myuser", OtherDBRoles: map[string][]Role{"db1": {RoleRead}}}), ShouldNotBeNil)
+
+		err := db.UpsertUser(&User{
+			Username:     "myuser",
+			Password:     "pass",
+			Roles:        []Role{RoleRead},
+			OtherDBRoles: map[string][]Role{"db1": {RoleReadWrite}},
+			CustomData:   bson.M{"name": "My User"},
+		})
+		So(err, ShouldBeNil)
+		user, err := db.UserInfo("myuser")
+		So(err, ShouldBeNil)
+		So(user.Username, ShouldEqual, "myuser")
+		So(user.Roles, ShouldResemble, []Role{RoleRead})
+		So(user.OtherDBRoles, ShouldResemble, map[string][]Role{"db1": {RoleReadWrite}})
+		So(user.CustomData, ShouldResemble, bson.D{{Key: "name", Value: "My User"}})
+
+		// Nil roles are kept.
+		So(db.UpsertUser(&User{Username: "myuser", Password: "other"}), ShouldBeNil)
+		user, err = db.UserInfo("myuser")
+		So(err, ShouldBeNil)
+		So(user.Roles, ShouldResemble, []Role{RoleRead})
+
+		So(db.GrantRolesToUser("myuser", DBRole{Role: RoleDBAdmin}, DBRole{Role: RoleRead, DB: "db2"}), ShouldBeNil)
+		So(db.RevokeRolesFromUser("myuser", DBRole{Role: RoleReadWrite, DB: "db1"}), ShouldBeNil)
+		user, err = db.UserInfo("myuser")
+		So(err, ShouldBeNil)
+		So(user.Roles, ShouldHaveLength, 2)
+		So(user.Roles, ShouldContain, RoleDBAdmin)
+		So(user.OtherDBRoles, ShouldResemble, map[string][]Role{"db2": {RoleRead}})
+		So(db.GrantRolesToUser("nouser", DBRole{Role: RoleRead}), ShouldEqual, ErrNotFound)
+
+		So(ctx.mongo.DB("mydb").AddUser("reader", "pass", true), ShouldBeNil)
+		users, err := ctx.mongo.DB("mydb").UsersInfo()
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].Roles, ShouldResemble, []Role{RoleRead})
+
+		So(db.RemoveUser("myuser"), ShouldBeNil)
+		So(db.RemoveUser("myuser"), ShouldEqual, ErrNotFound)
+		_, err = db.UserInfo("myuser")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+}
+
+func TestDatabase_CreateRole(t *testing.T) {
+	MongoTest(t, func(ctx *TestContext) {
+		db := ctx.mongo.DB("mydb")
+		err := db.CreateRole(&CustomRole{
+			Role: "ordersReader",
+			Privileges: []Privilege{
+				{Resource: Resource{DB: "mydb", Collection: "orders"}, Actions: []string{"find"}},
+			},
+			Roles: []DBRole{{Role: RoleRead, DB: "db1"}},
+		})
+		So(err, ShouldBeNil)
+
+		role, err := db.RoleInfo("ordersReader")
+		So(err, ShouldBeNil)
+		So(role.DB, ShouldEqual, "mydb")
+		So(role.Privileges, ShouldResemble, []Privilege{
+			{Resource: Resource{DB: "mydb", Collection: "orders"}, Actions: []string{"find"}},
+		})
+		So(role.Roles, ShouldResemble, []DBRole{{Role: RoleRead, DB: "db1"}})
+
+		So(db.UpsertUser(&User{Username: "myuser", Password: "pass", Roles: []Role{"ordersReader"}}), ShouldBeNil)
+		user, err := db.UserInfo("myuser")
+		So(err, ShouldBeNil)
+		So(user.Roles, ShouldResemble, []Role{"ordersReader"})
+
+		So(db.DropRole("ordersReader"), ShouldBeNil)
+		So(db.DropRole("ordersReader"), ShouldEqual, ErrNotFound)
+		_, err = db.RoleInfo("ordersReader")
+		So(err, ShouldEqual, ErrNotFound)
+	})
+}
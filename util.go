@@ -19,7 +19,26 @@ func IsDup(err error) bool {
 		return raw.WriteError.Code == 11000
 	case mongo.WriteError:
 		return raw.Code == 11000
+	case mongo.WriteException:
+		for _, werr := range raw.WriteErrors {
+			if werr.Code != 11000 {
+				return false
+			}
+		}
+		return len(raw.WriteErrors) > 0
+	case mongo.BulkWriteException:
+		for _, werr := range raw.WriteErrors {
+			if !IsDup(werr) {
+				return false
+			}
+		}
+		return len(raw.WriteErrors) > 0
+	case mongo.CommandError:
+		return raw.Code == 11000
 	case *BulkError:
+		if len(raw.ecases) == 0 {
+			return IsDup(raw.err)
+		}
 		for _, ecase := range raw.Cases() {
 			if !IsDup(ecase.Err) {
 				return false
@@ -153,8 +172,27 @@ func queryID(id interface{}) (realID interface{}) {
 	}
 	return
 }
-func resolveAddr(addr string) (*net.TCPAddr, error) {
-	// Simple cases that do not need actual resolution. Works with IPv4 and v6.
+
+// contextOrBackground returns ctx, or context.Background if ctx is nil.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// withDriverSession returns ctx carrying sess, unless sess is nil or ctx
+// already carries a driver session.
+func withDriverSession(ctx context.Context, sess mongo.Session) context.Context {
+	if sess == nil || mongo.SessionFromContext(ctx) != nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, sess)
+}
+
+// parseTCPAddr returns the TCP address of addr if it holds an IP address
+// and port, or nil otherwise.
+func parseTCPAddr(addr string) *net.TCPAddr {
 	if host, port, err := net.SplitHostPort(addr); err == nil {
 		if port, _ := strconv.Atoi(port); port > 0 {
 			zone := ""
@@ -164,10 +202,18 @@ func resolveAddr(addr string) (*net.TCPAddr, error) {
 			}
 			ip := net.ParseIP(host)
 			if ip != nil {
-				return &net.TCPAddr{IP: ip, Port: port, Zone: zone}, nil
+				return &net.TCPAddr{IP: ip, Port: port, Zone: zone}
 			}
 		}
 	}
+	return nil
+}
+
+func resolveAddr(ctx context.Context, addr string) (*net.TCPAddr, error) {
+	// Simple cases that do not need actual resolution. Works with IPv4 and v6.
+	if tcpaddr := parseTCPAddr(addr); tcpaddr != nil {
+		return tcpaddr, nil
+	}
 
 	// Attempt to resolve IPv4 and v6 concurrently.
 	addrChan := make(chan *net.TCPAddr, 2)
@@ -175,7 +221,8 @@ func resolveAddr(addr string) (*net.TCPAddr, error) {
 		network := network
 		go func() {
 			// The unfortunate UDP dialing hack allows having a timeout on address resolution.
-			conn, err := net.DialTimeout(network, addr, 10*time.Second)
+			dialer := net.Dialer{Timeout: 10 * time.Second}
+			conn, err := dialer.DialContext(ctx, network, addr)
 			if err != nil {
 				addrChan <- nil
 			} else {
@@ -188,13 +235,15 @@ func resolveAddr(addr string) (*net.TCPAddr, error) {
 	// Wait for the result of IPv4 and v6 resolution. Use IPv4 if available.
 	tcpaddr := <-addrChan
 	if tcpaddr == nil || len(tcpaddr.IP) != 4 {
-		ctx := context.TODO()
+		waitCtx := ctx
 		if tcpaddr != nil {
 			// Don't wait too long if an IPv6 address is known.
-			ctx, _ = context.WithTimeout(ctx, 50*time.Millisecond)
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
 		}
 		select {
-		case <-ctx.Done():
+		case <-waitCtx.Done():
 		case tcpaddr2 := <-addrChan:
 			if tcpaddr == nil || tcpaddr2 != nil {
 				// It's an IPv4 address or the only known address. Use it.
@@ -204,11 +253,11 @@ func resolveAddr(addr string) (*net.TCPAddr, error) {
 	}
 
 	if tcpaddr == nil {
-		//log("SYNC Failed to resolve server address: ", addr)
+		logf("SYNC Failed to resolve server address: %s", addr)
 		return nil, errors.New("failed to resolve server address: " + addr)
 	}
 	if tcpaddr.String() != addr {
-		//debug("SYNC Address ", addr, " resolved as ", tcpaddr.String())
+		debugf("SYNC Address %s resolved as %s", addr, tcpaddr.String())
 	}
 	return tcpaddr, nil
 }